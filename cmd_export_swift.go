@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/jtopjian/limbo/lib"
 
 	"github.com/sirupsen/logrus"
@@ -23,8 +25,12 @@ var cmdExportSwift = cli.Command{
 func init() {
 	cmdExportSwift.Flags = append(cmdExportSwift.Flags, lxdFlags...)
 	cmdExportSwift.Flags = append(cmdExportSwift.Flags, swiftFlags...)
+	cmdExportSwift.Flags = append(cmdExportSwift.Flags, exportFlags...)
 	cmdExportSwift.Flags = append(cmdExportSwift.Flags, openStackFlags...)
 	cmdExportSwift.Flags = append(cmdExportSwift.Flags, cryptFlags...)
+	cmdExportSwift.Flags = append(cmdExportSwift.Flags, kmsFlags...)
+	cmdExportSwift.Flags = append(cmdExportSwift.Flags, signFlags...)
+	cmdExportSwift.Flags = append(cmdExportSwift.Flags, retryFlags...)
 }
 
 // actionExportSwift implements the actions to export an LXD resource
@@ -53,12 +59,12 @@ func actionExportSwift(ctx *cli.Context) error {
 	lxdResourceType := ctx.String("type")
 	log.Debugf("LXD resource type is: %s", lxdResourceType)
 
-	localTmpDir := ctx.String("tmpdir")
-	log.Debugf("Local tmpdir is: %s", localTmpDir)
-
 	lxdConfigDirectory := ctx.String("lxd-config-directory")
 	log.Debugf("LXD config directory is: %s", lxdConfigDirectory)
 
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
 	stopLXDContainer := ctx.Bool("stop")
 	log.Debugf("Stop container if it's running: %t", stopLXDContainer)
 
@@ -68,17 +74,74 @@ func actionExportSwift(ctx *cli.Context) error {
 	archive := ctx.Bool("archive")
 	log.Debugf("Images will be archived: %t", archive)
 
-	// Because the exported image might be large, save it locally temporarily
-	// instead of in memory.
-	log.Debugf("Creating tmpdir %s", localTmpDir)
-	tmpDir, err := ioutil.TempDir(localTmpDir, "limbo")
+	segmentSize := ctx.Int64("segment-size") * 1024 * 1024
+	log.Debugf("Segment size is: %d", segmentSize)
+
+	tmpDir := ctx.String("tmpdir")
+	log.Debugf("Segments are spooled to: %s", tmpDir)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	// Recovery shares only rebuild a key to decrypt with.
+	if ctx.String("recover-from") != "" {
+		return fmt.Errorf("--recover-from can only be used to decrypt")
+	}
+
+	// Read the encryption secrets before anything is changed in LXD.
+	var cryptOpts *lib.CryptOpts
+	if ctx.Bool("encrypt") || ctx.String("key-file") != "" || len(ctx.StringSlice("recipient")) > 0 || ctx.String("kms") != "" {
+		c, err := newCryptOpts(ctx, log, "", true, swiftClient)
+		if err != nil {
+			return err
+		}
+
+		if c.KeyManager != nil && c.KeyManager.KeyID() == "" {
+			return fmt.Errorf("--kms-key is required to encrypt with %s", c.KeyManager.Name())
+		}
+
+		// Ask for the passphrase now rather than after publishing.
+		if c.NeedsPassphrase() {
+			if c.Pass, err = c.PassFunc(); err != nil {
+				return err
+			}
+		}
+		cryptOpts = &c
+	}
+	log.Debugf("Image will be encrypted: %t", cryptOpts != nil)
+
+	obfuscateNames := ctx.Bool("obfuscate-names")
+	if obfuscateNames && cryptOpts == nil {
+		return fmt.Errorf("--obfuscate-names requires encryption")
+	}
+	log.Debugf("Object names will be obfuscated: %t", obfuscateNames)
+
+	tmpl, err := newNameTemplate(ctx)
 	if err != nil {
-		return fmt.Errorf("Unable to create temp directory: %s", err)
+		return err
+	}
+
+	labels, err := lib.ParseLabels(ctx.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+
+	if tmpl != nil && ctx.Bool("timestamp") {
+		return fmt.Errorf("--timestamp cannot be used with --object-name-template. Use {date} instead")
+	}
+
+	signingKey, err := readSigningKey(ctx)
+	if err != nil {
+		return err
 	}
-	defer os.RemoveAll(tmpDir)
+	log.Debugf("Image will be signed: %t", signingKey != nil)
 
 	// Create an LXD client.
-	lxdConfig, err := newLXDConfig(lxdConfigDirectory)
+	lxdConfig, err := newLXDConfig(lxdConfigDirectory, retryOpts)
 	if err != nil {
 		return fmt.Errorf("Unable to get LXD configuration: %s", err)
 	}
@@ -99,13 +162,6 @@ func actionExportSwift(ctx *cli.Context) error {
 		return fmt.Errorf("Unable to connect to LXD Server: %s", err)
 	}
 
-	// Get a Swift client.
-	log.Debug("Creating swift client")
-	swiftClient, err := newSwiftClient(ctx)
-	if err != nil {
-		return fmt.Errorf("Unable to create swift client: %s", err)
-	}
-
 	// See if the destination storage container exists.
 	// Configure the container to archive, if requested.
 	log.Debug("Configuring Swift container")
@@ -168,86 +224,343 @@ func actionExportSwift(ctx *cli.Context) error {
 			return fmt.Errorf("Unable to connect to LXD image API: %s", err)
 		}
 
-		result, _, err := lxdImage.GetImageAlias(ctName)
-		if result == nil {
-			lxdFingerprint = ctName
+		lxdFingerprint = ctName
+		if result, _, err := lxdImage.GetImageAlias(ctName); err == nil {
+			lxdFingerprint = result.Target
 		}
-
-		lxdFingerprint = result.Target
-	}
-
-	// Download the image locally.
-	downloadOpts := lib.LXDDownloadOpts{
-		Name:        ctName,
-		TmpDir:      tmpDir,
-		Fingerprint: lxdFingerprint,
 	}
-	log.Debugf("LXD downloadOpts: %#v", downloadOpts)
 
-	log.Infof("Downloading image of %s", ctName)
-	downloadResult, err := lib.LXDDownloadImage(lxdConfig, downloadOpts)
+	// Describe the image for the manifest of the export.
+	manifest, err := lib.LXDDescribeImage(lxdConfig, lxdFingerprint)
 	if err != nil {
-		return fmt.Errorf("Unable to download lxd image: %s", err)
+		return err
 	}
-	log.Debugf("LXD downloadResult: %#v", downloadResult)
 
-	// Encrypt the file, if requested.
-	if ctx.Bool("encrypt") {
-		log.Infof("Encrypting %s", downloadResult.MetaFilename)
-		err = lib.Encrypt(downloadResult.MetaFilename, ctx.String("pass"))
-		if err != nil {
-			return fmt.Errorf("Unable to encrypt %s: %s", downloadResult.MetaFilename, err)
-		}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Warnf("Unable to determine hostname: %s", err)
 	}
 
-	// Upload the image to Swift.
 	objectName := ctx.String("object-name")
 	if objectName == "" {
 		objectName = ctName
 	}
 
-	// First upload the meta file.
-	uploadOpts := lib.SwiftUploadOpts{
+	// If requested, keep each export under its own timestamped name.
+	// The base name is used for the @latest pointer.
+	pointerName := objectName + lib.SwiftLatestSuffix
+	updatePointer := ctx.Bool("timestamp")
+	if updatePointer {
+		objectName = objectName + "@" + time.Now().UTC().Format(lib.NameTemplateDateLayout)
+	}
+
+	// A template with a {date} keeps each export under its own name as
+	// well.
+	if tmpl != nil {
+		values := lib.NameValues{
+			Host:        hostname,
+			Remote:      remote,
+			Name:        ctName,
+			Type:        lxdResourceType,
+			Fingerprint: lxdFingerprint,
+			Date:        time.Now().UTC(),
+		}
+
+		if objectName, err = tmpl.Expand(values); err != nil {
+			return err
+		}
+
+		if tmpl.HasDate() {
+			pointerName, err = tmpl.PointerName(values)
+			if err != nil {
+				log.Warnf("No @latest pointer is kept: %s", err)
+			}
+			updatePointer = err == nil
+		}
+	}
+
+	// If requested, store the image and its pointer under opaque names.
+	// Index entries map the logical names to them.
+	var indexEntries []lib.IndexEntry
+	if obfuscateNames {
+		names := []*string{&objectName}
+		if updatePointer {
+			names = append(names, &pointerName)
+		}
+
+		for _, name := range names {
+			opaque, err := lib.ObfuscateName(*name, *cryptOpts)
+			if err != nil {
+				return err
+			}
+
+			log.Debugf("Storing %s as %s", *name, opaque)
+			indexEntries = append(indexEntries, lib.IndexEntry{
+				Name:    *name,
+				Object:  opaque,
+				Created: time.Now().UTC(),
+			})
+			*name = opaque
+		}
+	}
+
+	// The image is streamed from LXD, through encryption, to Swift. Only
+	// the segment being uploaded is held in a temporary file.
+	metaUploadOpts := lib.SwiftUploadOpts{
 		ObjectName:       objectName,
-		SourceName:       downloadResult.MetaFilename,
+		SegmentSize:      segmentSize,
 		StorageContainer: storageContainerName,
+		TempDir:          tmpDir,
+	}
+
+	// Labels are kept in the metadata of the meta object as well, so they
+	// can be read when the manifest cannot.
+	if len(labels) > 0 {
+		metaUploadOpts.Metadata = map[string]string{
+			lib.LabelsMetadataKey: labels.String(),
+		}
 	}
-	log.Debugf("Swift uploadOpts: %#v", uploadOpts)
+	log.Debugf("Swift uploadOpts: %#v", metaUploadOpts)
+
+	rootfsUploadOpts := metaUploadOpts
+	rootfsUploadOpts.ObjectName = objectName + ".root"
+	rootfsUploadOpts.Metadata = nil
+	log.Debugf("Swift uploadOpts: %#v", rootfsUploadOpts)
 
 	log.Infof("Uploading %s to Swift container %s as %s",
-		ctName, uploadOpts.StorageContainer, uploadOpts.ObjectName)
+		ctName, storageContainerName, objectName)
+
+	// A download from LXD cannot be resumed, so an export which failed
+	// because of a transient error is streamed again from the start. The
+	// segments uploaded so far are discarded. Other errors would only
+	// occur again.
+	var metaUpload, rootfsUpload *lib.SwiftUpload
+	var streamResult *lib.LXDStreamResult
+	err = retryOpts.Do("export of "+ctName, func() error {
+		metaUpload, rootfsUpload = nil, nil
+		streamOpts := lib.LXDStreamOpts{
+			Fingerprint: lxdFingerprint,
+			MetaFile:    newExportWriter(swiftClient, metaUploadOpts, cryptOpts, &metaUpload),
+			RootfsFile:  newExportWriter(swiftClient, rootfsUploadOpts, cryptOpts, &rootfsUpload),
+		}
+		log.Debugf("LXD streamOpts: %#v", streamOpts)
 
-	uploadResult, err := lib.SwiftUploadObject(swiftClient, uploadOpts)
+		var err error
+		streamResult, err = lib.LXDStreamImage(lxdConfig, streamOpts)
+		if err != nil {
+			for _, upload := range []*lib.SwiftUpload{metaUpload, rootfsUpload} {
+				if upload != nil {
+					upload.Abort()
+				}
+			}
+		}
+
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("Unable to upload meta file to swift: %s", err)
+		return fmt.Errorf("Unable to export image: %s", err)
 	}
-	log.Debugf("Upload result headers: %#v", uploadResult.Headers)
+	log.Debugf("LXD streamResult: %#v", streamResult)
 
-	// Then upload the rootfs file, if it exists.
-	if downloadResult.RootfsFilename != "" {
-		// Encrypt the file, if requested.
-		if ctx.Bool("encrypt") {
-			log.Infof("Encrypting %s", downloadResult.RootfsFilename)
-			err = lib.Encrypt(downloadResult.RootfsFilename, ctx.String("pass"))
-			if err != nil {
-				return fmt.Errorf("Unable to encrypt %s: %s", downloadResult.RootfsFilename, err)
-			}
+	manifest.Image = objectName
+	manifest.Source = lib.ManifestSource{
+		Remote: remote,
+		Name:   ctName,
+		Type:   lxdResourceType,
+	}
+	manifest.Compression = lib.Compression(streamResult.MetaName)
+	if len(labels) > 0 {
+		manifest.Labels = labels
+	}
+	manifest.Encryption = "none"
+	if cryptOpts != nil {
+		manifest.Encryption = cryptOpts.Scheme()
+	}
+	manifest.Created = time.Now().UTC()
+	manifest.Hostname = hostname
+	manifest.LimboVersion = version
+	manifest.Objects = []lib.ManifestObject{
+		lib.ManifestObject{
+			Name:     objectName,
+			Role:     lib.ManifestRoleMeta,
+			Filename: streamResult.MetaName,
+			Size:     metaUpload.Size,
+			SHA256:   metaUpload.SHA256,
+		},
+	}
+
+	// Keep copies of the objects this export replaces, so a failed export
+	// leaves the previous one intact. Objects which did not exist before
+	// are deleted again.
+	backupNames := []string{objectName, objectName + lib.SwiftManifestSuffix, objectName + lib.SwiftSignatureSuffix}
+	if streamResult.RootfsSize > 0 {
+		backupNames = append(backupNames, objectName+".root")
+	}
+
+	for _, entry := range indexEntries {
+		backupNames = append(backupNames, lib.SwiftIndexPrefix+entry.Object)
+	}
+
+	backup, err := lib.SwiftBackupObjects(swiftClient, storageContainerName, backupNames)
+	if err != nil {
+		metaUpload.Abort()
+		rootfsUpload.Abort()
+		return err
+	}
+
+	// rollback restores the previous export and deletes the segments of
+	// the new one. The segments of a committed rootfs are only deleted
+	// once the previous rootfs is back in place.
+	var rootfsCommitted bool
+	rollback := func(err error) error {
+		metaUpload.Abort()
+		if !rootfsCommitted {
+			rootfsUpload.Abort()
 		}
 
-		uploadOpts.SourceName = downloadResult.RootfsFilename
-		uploadOpts.ObjectName = objectName + ".root"
-		log.Debugf("Swift uploadOpts: %#v", uploadOpts)
+		if restoreErr := backup.Restore(); restoreErr != nil {
+			return fmt.Errorf("%s. Unable to restore the previous export: %s", err, restoreErr)
+		}
+		backup.Discard()
 
-		log.Infof("Uploading %s rootfs to Swift container %s as %s",
-			ctName, uploadOpts.StorageContainer, uploadOpts.ObjectName)
+		if rootfsCommitted {
+			lib.SwiftDeleteUnusedSegments(swiftClient, storageContainerName,
+				[]string{objectName + ".root"}, rootfsUpload.Segments())
+		}
+
+		return err
+	}
 
-		uploadResult, err = lib.SwiftUploadObject(swiftClient, uploadOpts)
+	// Make the rootfs file visible first, if it exists, then the manifest,
+	// the signature and then the meta file.
+	signedObjects := []lib.SignedObject{
+		lib.SignedObject{
+			Name:   objectName,
+			Size:   metaUpload.Size,
+			SHA256: metaUpload.SHA256,
+		},
+	}
+
+	if streamResult.RootfsSize > 0 {
+		log.Infof("Saving %s rootfs as %s", ctName, objectName+".root")
+		uploadResult, err := rootfsUpload.Commit()
 		if err != nil {
-			return fmt.Errorf("Unable to upload meta file to swift: %s", err)
+			return rollback(fmt.Errorf("Unable to upload rootfs file to swift: %s", err))
 		}
+		rootfsCommitted = true
 		log.Debugf("Upload result headers: %#v", uploadResult.Headers)
+
+		signedObjects = append(signedObjects, lib.SignedObject{
+			Name:   objectName + ".root",
+			Size:   rootfsUpload.Size,
+			SHA256: rootfsUpload.SHA256,
+		})
+
+		manifest.Objects = append(manifest.Objects, lib.ManifestObject{
+			Name:     objectName + ".root",
+			Role:     lib.ManifestRoleRootfs,
+			Filename: streamResult.RootfsName,
+			Size:     rootfsUpload.Size,
+			SHA256:   rootfsUpload.SHA256,
+		})
+	}
+
+	// The manifest would reveal the name of an obfuscated image, so it is
+	// encrypted as well.
+	manifestOpts := lib.SwiftManifestOpts{
+		StorageContainer: storageContainerName,
+		ObjectName:       objectName,
+		Manifest:         *manifest,
+	}
+
+	if obfuscateNames {
+		manifestOpts.CryptOpts = cryptOpts
+	}
+
+	log.Infof("Saving manifest as %s", objectName+lib.SwiftManifestSuffix)
+	manifestSigned, err := lib.SwiftWriteManifest(swiftClient, manifestOpts)
+	if err != nil {
+		return rollback(err)
+	}
+	signedObjects = append(signedObjects, *manifestSigned)
+
+	if signingKey != nil {
+		signOpts := lib.SwiftSignOpts{
+			StorageContainer: storageContainerName,
+			ObjectName:       objectName,
+			Objects:          signedObjects,
+			Key:              signingKey,
+		}
+
+		log.Infof("Signing %s", objectName)
+		if err := lib.SwiftSignImage(swiftClient, signOpts); err != nil {
+			return rollback(err)
+		}
+	}
+
+	for _, entry := range indexEntries {
+		log.Debugf("Adding index entry for %s", entry.Name)
+		if err := lib.SwiftAddIndexEntry(swiftClient, storageContainerName, entry, *cryptOpts); err != nil {
+			return rollback(err)
+		}
+	}
+
+	log.Infof("Saving %s as %s", ctName, objectName)
+	uploadResult, err := metaUpload.Commit()
+	if err != nil {
+		return rollback(fmt.Errorf("Unable to upload meta file to swift: %s", err))
+	}
+	log.Debugf("Upload result headers: %#v", uploadResult.Headers)
+	backup.Discard()
+
+	// Only point to the new export once all of its objects were uploaded.
+	if updatePointer {
+		pointerOpts := lib.SwiftPointerOpts{
+			StorageContainer: storageContainerName,
+			PointerName:      pointerName,
+			Target:           objectName,
+		}
+		log.Debugf("Swift pointerOpts: %#v", pointerOpts)
+
+		log.Infof("Pointing %s to %s", pointerName, objectName)
+		if err := lib.SwiftUpdatePointer(swiftClient, pointerOpts); err != nil {
+			return err
+		}
 	}
 
 	log.Infof("Successfully exported %s", ctName)
 	return nil
 }
+
+// newExportWriter returns a writer which uploads everything written to it to
+// Swift, encrypting it first if cryptOpts is set. The finished upload is
+// stored in upload.
+func newExportWriter(swiftClient *gophercloud.ServiceClient, uploadOpts lib.SwiftUploadOpts, cryptOpts *lib.CryptOpts, upload **lib.SwiftUpload) io.WriteCloser {
+	var uploadErr error
+	uploadWriter := lib.NewStreamWriter(func(r io.Reader) error {
+		u, err := lib.SwiftUploadStream(swiftClient, uploadOpts, r)
+		*upload = u
+		uploadErr = err
+		return err
+	})
+
+	if cryptOpts == nil {
+		return uploadWriter
+	}
+
+	return lib.NewStreamWriter(func(r io.Reader) error {
+		if err := lib.Encrypt(uploadWriter, r, *cryptOpts); err != nil {
+			uploadWriter.CloseWithError(err)
+
+			// A failed upload makes encrypting fail as well. Only the
+			// error of the upload tells if it can be retried.
+			if lib.IsTransient(uploadErr) {
+				return uploadErr
+			}
+
+			return err
+		}
+
+		return uploadWriter.Close()
+	})
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io"
+	"strings"
+	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/jtopjian/limbo/lib"
 
 	"github.com/sirupsen/logrus"
@@ -22,8 +25,12 @@ var cmdImportSwift = cli.Command{
 func init() {
 	cmdImportSwift.Flags = append(cmdImportSwift.Flags, lxdFlags...)
 	cmdImportSwift.Flags = append(cmdImportSwift.Flags, swiftFlags...)
+	cmdImportSwift.Flags = append(cmdImportSwift.Flags, importFlags...)
 	cmdImportSwift.Flags = append(cmdImportSwift.Flags, openStackFlags...)
 	cmdImportSwift.Flags = append(cmdImportSwift.Flags, cryptFlags...)
+	cmdImportSwift.Flags = append(cmdImportSwift.Flags, kmsFlags...)
+	cmdImportSwift.Flags = append(cmdImportSwift.Flags, signFlags...)
+	cmdImportSwift.Flags = append(cmdImportSwift.Flags, retryFlags...)
 }
 
 // actionImportSwift implements the actions to import an LXD resource
@@ -34,10 +41,24 @@ func actionImportSwift(ctx *cli.Context) error {
 		log.Level = logrus.DebugLevel
 	}
 
-	// A name is required.
+	// A name, a template or a selector is required.
 	objectName := ctx.String("object-name")
-	if objectName == "" {
-		return fmt.Errorf("must specify --object-name")
+	tmpl, err := newNameTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	selector, err := newSelector(ctx)
+	if err != nil {
+		return err
+	}
+
+	if objectName == "" && tmpl == nil && selector == nil {
+		return fmt.Errorf("must specify --object-name, --object-name-template or --selector")
+	}
+
+	if objectName == "" && ctx.Bool("obfuscate-names") {
+		return fmt.Errorf("--obfuscate-names requires --object-name")
 	}
 	log.Debugf("Source name is: %s", objectName)
 
@@ -49,36 +70,69 @@ func actionImportSwift(ctx *cli.Context) error {
 	log.Debugf("Storage container name is: %s", storageContainerName)
 
 	// Set some variables.
-	localTmpDir := ctx.String("tmpdir")
-	log.Debugf("Local tmpdir is: %s", localTmpDir)
-
 	lxdConfigDirectory := ctx.String("lxd-config-directory")
 	log.Debugf("LXD config directory is: %s", lxdConfigDirectory)
 
-	// Because the downloaded image might be large, save it locally temporarily
-	// instead of in memory.
-	log.Debugf("Creating tmpdir %s", localTmpDir)
-	tmpDir, err := ioutil.TempDir(localTmpDir, "limbo")
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	cryptOpts, err := newCryptOpts(ctx, log, "", false, swiftClient)
 	if err != nil {
-		return fmt.Errorf("Unable to create temp directory: %s", err)
+		return err
+	}
+
+	// Without --object-name, pick the newest image the template built and
+	// the selector matches.
+	if objectName == "" {
+		objectName, err = findImage(log, swiftClient, storageContainerName, tmpl, selector)
+		if err != nil {
+			return err
+		}
+	}
+
+	// If --name is specified, use it. If not, use the {name} of a template
+	// which built the object name, or the object name without any
+	// @timestamp or @latest suffix.
+	lxdContainerName := strings.SplitN(objectName, "@", 2)[0]
+	if tmpl != nil {
+		if values, ok := tmpl.Match(objectName); ok {
+			lxdContainerName = values.Name
+			if lxdContainerName == "" && ctx.String("name") == "" {
+				return fmt.Errorf("--name is required as %s has no {name}", tmpl)
+			}
+		}
 	}
-	defer os.RemoveAll(tmpDir)
 
-	// If --name is specified, use it. If not, use --object-name.
-	lxdContainerName := objectName
 	if v := ctx.String("name"); v != "" {
 		lxdContainerName = v
 	}
 
-	// Get a Swift client.
-	log.Debug("Creating swift client")
-	swiftClient, err := newSwiftClient(ctx)
+	objectName, err = resolveImageName(ctx, log, swiftClient, storageContainerName, objectName, cryptOpts)
 	if err != nil {
-		return fmt.Errorf("Unable to create swift client: %s", err)
+		return err
+	}
+
+	// An image given by name must match the selector as well.
+	if selector != nil && ctx.String("object-name") != "" {
+		labels, err := lib.SwiftGetLabels(swiftClient, storageContainerName, objectName)
+		if err != nil {
+			return err
+		}
+
+		if !selector.Matches(labels) {
+			return fmt.Errorf("The labels of %s do not match --selector: %s", objectName, labels)
+		}
 	}
 
 	// Create an LXD client.
-	lxdConfig, err := newLXDConfig(lxdConfigDirectory)
+	lxdConfig, err := newLXDConfig(lxdConfigDirectory, retryOpts)
 	if err != nil {
 		return fmt.Errorf("Unable to get LXD configuration: %s", err)
 	}
@@ -92,93 +146,220 @@ func actionImportSwift(ctx *cli.Context) error {
 	log.Debugf("LXD Remote: %s", remote)
 	log.Debugf("LXD Container name: %s", ctName)
 
-	// Download the image from Swift.
+	if _, err := importSwiftImage(ctx, log, swiftClient, lxdConfig, storageContainerName, objectName, ctName, ctx.StringSlice("alias"), cryptOpts); err != nil {
+		return err
+	}
+
+	log.Infof("Successfully imported %s", ctName)
+	return nil
+}
+
+// importSwiftImage streams an image from Swift into LXD under the name
+// ctName. If trusted keys were given, only a validly signed image is
+// imported. The signature and the objects it lists are checked before the
+// upload to LXD starts, but the content of the objects can only be checked
+// while it is streamed. If the import fails, an image which LXD stored
+// anyway is deleted again.
+func importSwiftImage(ctx *cli.Context, log *logrus.Logger, swiftClient *gophercloud.ServiceClient, lxdConfig lib.LXDConfig, storageContainerName, objectName, ctName string, aliases []string, cryptOpts lib.CryptOpts) (*lib.LXDImportResult, error) {
+	// OpenPGP messages have no header which identifies them, so they are
+	// treated like data encrypted by older versions of limbo.
+	legacy := ctx.Bool("encrypt") || cryptOpts.Mode == lib.CryptModeOpenPGP
+
+	// If trusted keys were given, only import a validly signed image.
+	verifyKeys, err := readVerifyKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var signature *lib.ImageSignature
+	if verifyKeys != nil {
+		log.Infof("Verifying signature of %s", objectName)
+		signature, err = lib.SwiftVerifyImage(swiftClient, storageContainerName, objectName, verifyKeys)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Image signature: %#v", signature)
+
+		if err := lib.SwiftCheckSignedObjects(swiftClient, storageContainerName, objectName, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	// The manifest lists the objects of the image.
+	manifest, err := readImageManifest(log, swiftClient, storageContainerName, objectName, cryptOpts, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest != nil {
+		log.Infof("%s was exported from %s on %s by %s", objectName, manifest.Source.Name,
+			manifest.Created.Format(time.RFC3339), manifest.Hostname)
+		log.Debugf("Image manifest: %#v", manifest)
 
-	// First download the meta file.
-	metaFilename := tmpDir + "/" + objectName
+		if strings.HasPrefix(manifest.Encryption, lib.CryptModeOpenPGP) && cryptOpts.Mode != lib.CryptModeOpenPGP {
+			return nil, fmt.Errorf("%s is encrypted in OpenPGP mode. Use --encrypt-mode openpgp", objectName)
+		}
+	}
+
+	// The image is streamed from Swift, through decryption, to LXD.
+	// Nothing is stored locally.
 	downloadOpts := lib.SwiftDownloadOpts{
-		Filename:         metaFilename,
 		ObjectName:       objectName,
 		StorageContainer: storageContainerName,
 	}
 	log.Debugf("Swift downloadOpts: %#v", downloadOpts)
 
-	log.Infof("Downloading %s from Swift container %s as %s",
-		objectName, storageContainerName, metaFilename)
-
-	downloadResult, err := lib.SwiftDownloadObject(swiftClient, downloadOpts)
+	metaSigned, err := signedObject(signature, objectName)
 	if err != nil {
-		return fmt.Errorf("Unable to download meta file to swift: %s", err)
+		return nil, err
 	}
-	log.Debugf("Download result headers: %#v", downloadResult.Headers)
 
-	// Then download the rootfs file, if it exists.
-	rootfsObjectName := objectName + ".root"
-	rootfsFilename := metaFilename + ".root"
-	downloadOpts.ObjectName = rootfsObjectName
-	downloadOpts.Filename = rootfsFilename
+	metaFile := newImportReader(swiftClient, downloadOpts, cryptOpts, legacy, metaSigned)
+	defer metaFile.Close()
 
-	rootfsObjectExists := true
-	downloadResult, err = lib.SwiftDownloadObject(swiftClient, downloadOpts)
-	if err != nil {
-		// If the error was a 404/does not exist
-		if _, ok := err.(lib.ErrObjectDoesNotExist); !ok {
-			return err
+	importOpts := lib.LXDImportOpts{
+		Aliases:  aliases,
+		Name:     ctName,
+		MetaFile: metaFile,
+		MetaName: objectName,
+	}
+
+	// Then the rootfs file, if the image has one.
+	rootfsObjectName := objectName + ".root"
+	var rootfsObjectExists bool
+	if manifest != nil {
+		rootfs := manifest.Object(lib.ManifestRoleRootfs)
+		if rootfs != nil {
+			rootfsObjectName = rootfs.Name
+			rootfsObjectExists = true
 		}
+	} else {
+		rootfsObjectExists, err = lib.SwiftObjectExists(swiftClient, storageContainerName, rootfsObjectName)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		rootfsObjectExists = false
+	if !rootfsObjectExists && signature != nil && signature.Object(rootfsObjectName) != nil {
+		return nil, fmt.Errorf("%s is signed but does not exist", rootfsObjectName)
 	}
 
 	if rootfsObjectExists {
-		log.Infof("Downloaded %s from Swift container %s as %s",
-			rootfsObjectName, storageContainerName, rootfsFilename)
-		log.Debugf("Download result headers: %#v", downloadResult.Headers)
-	}
+		downloadOpts.ObjectName = rootfsObjectName
+		log.Debugf("Swift downloadOpts: %#v", downloadOpts)
 
-	// If the object is encrypted, decrypt it.
-	if ctx.Bool("encrypt") {
-		log.Infof("Decrypting %s", objectName)
-		err = lib.Decrypt(metaFilename, ctx.String("pass"))
+		rootfsSigned, err := signedObject(signature, rootfsObjectName)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if rootfsObjectExists {
-			log.Infof("Decrypting %s", rootfsObjectName)
-			err = lib.Decrypt(rootfsFilename, ctx.String("pass"))
-			if err != nil {
-				return err
-			}
-		}
-	}
+		rootfsFile := newImportReader(swiftClient, downloadOpts, cryptOpts, legacy, rootfsSigned)
+		defer rootfsFile.Close()
 
-	// Import the image into LXD.
-	importOpts := lib.LXDImportOpts{
-		Name:         ctName,
-		MetaFilename: metaFilename,
-		TmpDir:       tmpDir,
+		importOpts.RootfsFile = rootfsFile
+		importOpts.RootfsName = rootfsObjectName
 	}
 
-	if rootfsObjectExists {
-		importOpts.RootfsFilename = metaFilename + ".root"
-	}
+	// Only an image which did not exist before is removed if the import
+	// fails.
+	var fingerprint string
+	if manifest != nil && manifest.Fingerprint != "" {
+		exists, err := lib.LXDImageExists(lxdConfig, manifest.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
 
-	if len(ctx.StringSlice("alias")) > 0 {
-		aliases := []string{}
-		for _, v := range ctx.StringSlice("alias") {
-			aliases = append(aliases, v)
+		if !exists {
+			fingerprint = manifest.Fingerprint
 		}
-		importOpts.Aliases = aliases
 	}
 
-	log.Infof("Importing %s", ctName)
+	log.Infof("Importing %s from Swift container %s as %s",
+		objectName, storageContainerName, ctName)
 	log.Debugf("LXD importOpts: %#v", importOpts)
 	importResult, err := lib.LXDImportImage(lxdConfig, importOpts)
 	if err != nil {
-		return fmt.Errorf("Unable to import image %s: %s", ctName, err)
+		err = fmt.Errorf("Unable to import image %s: %s", ctName, err)
+		if importResult != nil {
+			fingerprint = importResult.Fingerprint
+		}
+
+		if fingerprint != "" {
+			if cleanupErr := removeImportedImage(log, lxdConfig, fingerprint); cleanupErr != nil {
+				return nil, fmt.Errorf("%s. %s", err, cleanupErr)
+			}
+		}
+
+		return nil, err
 	}
 	log.Debugf("LXD importResult: %#v", importResult)
 
-	log.Infof("Successfully imported %s", ctName)
+	return importResult, nil
+}
+
+// removeImportedImage deletes the image with the given fingerprint if LXD
+// stored it.
+func removeImportedImage(log *logrus.Logger, lxdConfig lib.LXDConfig, fingerprint string) error {
+	exists, err := lib.LXDImageExists(lxdConfig, fingerprint)
+	if err != nil {
+		return fmt.Errorf("Unable to check for image %s: %s", fingerprint, err)
+	}
+
+	if !exists {
+		return nil
+	}
+
+	log.Infof("Removing image %s of the failed import", fingerprint)
+	if err := lib.LXDDeleteImage(lxdConfig, fingerprint); err != nil {
+		return fmt.Errorf("Unable to remove image %s: %s", fingerprint, err)
+	}
+
 	return nil
 }
+
+// newImportReader returns a reader which downloads an object from Swift,
+// decrypting it if it is encrypted. Data encrypted by older versions of limbo
+// and OpenPGP messages have no header and are only decrypted if legacy is
+// set. If signed is set, the downloaded data must match it. The download
+// starts on the first read.
+func newImportReader(swiftClient *gophercloud.ServiceClient, downloadOpts lib.SwiftDownloadOpts, cryptOpts lib.CryptOpts, legacy bool, signed *lib.SignedObject) io.ReadCloser {
+	return lib.NewStreamReader(func(w io.Writer) error {
+		downloadResult, err := lib.SwiftDownloadObject(swiftClient, downloadOpts)
+		if err != nil {
+			return fmt.Errorf("Unable to download %s from swift: %s", downloadOpts.ObjectName, err)
+		}
+		defer downloadResult.Content.Close()
+
+		var r io.Reader = downloadResult.Content
+		if signed != nil {
+			r = lib.NewVerifyingReader(r, *signed)
+		}
+
+		content := bufio.NewReader(r)
+		if lib.HasCryptHeader(content) || legacy {
+			if err := lib.Decrypt(w, content, cryptOpts); err != nil {
+				return fmt.Errorf("Unable to decrypt %s: %s", downloadOpts.ObjectName, err)
+			}
+
+			return nil
+		}
+
+		_, err = io.Copy(w, content)
+		return err
+	})
+}
+
+// signedObject returns the signed description of an object. If signature
+// is nil, nothing is verified and nil is returned.
+func signedObject(signature *lib.ImageSignature, name string) (*lib.SignedObject, error) {
+	if signature == nil {
+		return nil, nil
+	}
+
+	signed := signature.Object(name)
+	if signed == nil {
+		return nil, fmt.Errorf("%s is not covered by the signature", name)
+	}
+
+	return signed, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jtopjian/limbo/lib"
+
+	lxd_api "github.com/lxc/lxd/shared/api"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdInspectSwift defines a cli command to show the metadata of an image
+// stored in Swift.
+var cmdInspectSwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionInspectSwift,
+	Category: "inspect",
+}
+
+func init() {
+	cmdInspectSwift.Flags = append(cmdInspectSwift.Flags, inspectFlags...)
+	cmdInspectSwift.Flags = append(cmdInspectSwift.Flags, openStackFlags...)
+	cmdInspectSwift.Flags = append(cmdInspectSwift.Flags, cryptFlags...)
+	cmdInspectSwift.Flags = append(cmdInspectSwift.Flags, kmsFlags...)
+	cmdInspectSwift.Flags = append(cmdInspectSwift.Flags, retryFlags...)
+}
+
+// inspectResult is an image as it is printed by inspect.
+type inspectResult struct {
+	Name     string                 `json:"name"`
+	Manifest *lib.Manifest          `json:"manifest"`
+	Metadata *lxd_api.ImageMetadata `json:"metadata"`
+}
+
+// actionInspectSwift implements the actions to show the metadata of an
+// image stored in Swift. Only the meta object is downloaded. For unified
+// images, it is only read up to metadata.yaml.
+func actionInspectSwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// A name is required.
+	objectName := ctx.String("object-name")
+	if objectName == "" {
+		return fmt.Errorf("must specify --object-name")
+	}
+	log.Debugf("Object name is: %s", objectName)
+
+	// A storage container name is required.
+	storageContainerName := ctx.String("storage-container")
+	if storageContainerName == "" {
+		return fmt.Errorf("must specify --storage-container")
+	}
+	log.Debugf("Storage container name is: %s", storageContainerName)
+
+	format := ctx.String("format")
+	switch format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("Unknown format: %s", format)
+	}
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	cryptOpts, err := newCryptOpts(ctx, log, "", false, swiftClient)
+	if err != nil {
+		return err
+	}
+
+	objectName, err = resolveImageName(ctx, log, swiftClient, storageContainerName, objectName, cryptOpts)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := readImageManifest(log, swiftClient, storageContainerName, objectName, cryptOpts, nil)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Image manifest: %#v", manifest)
+
+	if manifest != nil && strings.HasPrefix(manifest.Encryption, lib.CryptModeOpenPGP) && cryptOpts.Mode != lib.CryptModeOpenPGP {
+		return fmt.Errorf("%s is encrypted in OpenPGP mode. Use --encrypt-mode openpgp", objectName)
+	}
+
+	// The meta object is not verified against the signature, as it may
+	// not be read to the end.
+	downloadOpts := lib.SwiftDownloadOpts{
+		ObjectName:       objectName,
+		StorageContainer: storageContainerName,
+	}
+	log.Debugf("Swift downloadOpts: %#v", downloadOpts)
+
+	legacy := ctx.Bool("encrypt") || cryptOpts.Mode == lib.CryptModeOpenPGP
+	metaFile := newImportReader(swiftClient, downloadOpts, cryptOpts, legacy, nil)
+	defer metaFile.Close()
+
+	log.Infof("Reading metadata of %s", objectName)
+	metadata, err := lib.ReadImageMetadata(metaFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read metadata of %s: %s", objectName, err)
+	}
+
+	result := inspectResult{
+		Name:     objectName,
+		Manifest: manifest,
+		Metadata: metadata,
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	return writeInspectText(os.Stdout, result)
+}
+
+func writeInspectText(w io.Writer, result inspectResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s\n", result.Name)
+
+	if m := result.Manifest; m != nil {
+		fmt.Fprintf(tw, "Source:\t%s:%s (%s)\n", m.Source.Remote, m.Source.Name, m.Source.Type)
+		fmt.Fprintf(tw, "Fingerprint:\t%s\n", m.Fingerprint)
+		fmt.Fprintf(tw, "Exported:\t%s by %s with limbo %s\n",
+			m.Created.Local().Format(time.RFC3339), m.Hostname, m.LimboVersion)
+		fmt.Fprintf(tw, "Compression:\t%s\n", m.Compression)
+		fmt.Fprintf(tw, "Encryption:\t%s\n", m.Encryption)
+		fmt.Fprintf(tw, "Size:\t%s\n", formatSize(m.Size()))
+		fmt.Fprintf(tw, "Objects:\n")
+		for _, o := range m.Objects {
+			fmt.Fprintf(tw, "  %s:\t%s (%s, sha256 %s)\n", o.Role, o.Name, formatSize(o.Size), o.SHA256)
+		}
+	}
+
+	md := result.Metadata
+	fmt.Fprintf(tw, "Architecture:\t%s\n", md.Architecture)
+	fmt.Fprintf(tw, "Created:\t%s\n", formatUnix(md.CreationDate))
+	fmt.Fprintf(tw, "Expires:\t%s\n", formatUnix(md.ExpiryDate))
+
+	if len(md.Properties) > 0 {
+		fmt.Fprintf(tw, "Properties:\n")
+		for _, k := range sortedKeys(md.Properties) {
+			fmt.Fprintf(tw, "  %s:\t%s\n", k, md.Properties[k])
+		}
+	}
+
+	if len(md.Templates) > 0 {
+		var paths []string
+		for path := range md.Templates {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		fmt.Fprintf(tw, "Templates:\n")
+		for _, path := range paths {
+			t := md.Templates[path]
+			fmt.Fprintf(tw, "  %s:\t%s (%s)\n", path, t.Template, strings.Join(t.When, ", "))
+		}
+	}
+
+	return tw.Flush()
+}
+
+// formatUnix formats a Unix timestamp from image metadata. 0 means unset.
+func formatUnix(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+
+	return time.Unix(ts, 0).Local().Format(time.RFC3339)
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
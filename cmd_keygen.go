@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdKeygen defines a cli command to generate encryption keys.
+var cmdKeygen = cli.Command{
+	Name:   "keygen",
+	Usage:  "generate an encryption key",
+	Action: actionKeygen,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "Type of key to generate: keypair, symmetric or signing.",
+			Value: "keypair",
+		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "File to write the key to. The public key of a keypair is written to <output>.pub.",
+		},
+		cli.IntFlag{
+			Name:  "shares",
+			Usage: "Split the key into this many shares, written to <output>.share<n>, instead of writing it.",
+		},
+		cli.IntFlag{
+			Name:  "threshold",
+			Usage: "Number of shares needed to rebuild a split key.",
+		},
+	},
+}
+
+// actionKeygen implements the actions to generate a key and write it to
+// a file which only the current user can read.
+func actionKeygen(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// An output file is required.
+	output := ctx.String("output")
+	if output == "" {
+		return fmt.Errorf("must specify --output")
+	}
+	log.Debugf("Output file is: %s", output)
+
+	keyType := ctx.String("type")
+	log.Debugf("Key type is: %s", keyType)
+
+	shares := ctx.Int("shares")
+	threshold := ctx.Int("threshold")
+	if shares > 0 || threshold > 0 {
+		if keyType == "signing" {
+			return fmt.Errorf("Signing keys can not be split")
+		}
+		log.Debugf("Key will be split into %d shares with a threshold of %d", shares, threshold)
+	}
+
+	switch keyType {
+	case "symmetric":
+		key, err := lib.GenerateSecretKey()
+		if err != nil {
+			return err
+		}
+
+		if shares > 0 || threshold > 0 {
+			return writeKeyShares(log, output, lib.PEMSecretKey, key[:], shares, threshold, nil)
+		}
+
+		if err := writeKeyFile(output, lib.MarshalKey(lib.PEMSecretKey, key[:]), 0600); err != nil {
+			return err
+		}
+
+		log.Infof("Wrote secret key to %s", output)
+	case "keypair":
+		publicKey, privateKey, err := lib.GenerateKeyPair()
+		if err != nil {
+			return err
+		}
+
+		public := lib.MarshalKey(lib.PEMPublicKey, publicKey[:])
+		if shares > 0 || threshold > 0 {
+			return writeKeyShares(log, output, lib.PEMPrivateKey, privateKey[:], shares, threshold, public)
+		}
+
+		private := lib.MarshalKey(lib.PEMPrivateKey, privateKey[:])
+		if err := writeKeyPair(output, private, public); err != nil {
+			return err
+		}
+
+		log.Infof("Wrote private key to %s and public key to %s", output, output+".pub")
+	case "signing":
+		publicKey, privateKey, err := lib.GenerateSigningKey()
+		if err != nil {
+			return err
+		}
+
+		private := lib.MarshalKey(lib.PEMSigningKey, privateKey)
+		public := lib.MarshalKey(lib.PEMVerifyKey, publicKey)
+		if err := writeKeyPair(output, private, public); err != nil {
+			return err
+		}
+
+		log.Infof("Wrote signing key to %s and verification key to %s", output, output+".pub")
+	default:
+		return fmt.Errorf("Unknown key type: %s", keyType)
+	}
+
+	return nil
+}
+
+// writeKeyPair writes a private key to path and the matching public key to
+// path.pub.
+func writeKeyPair(path string, private, public []byte) error {
+	publicPath := path + ".pub"
+	if _, err := os.Stat(publicPath); err == nil {
+		return fmt.Errorf("%s already exists", publicPath)
+	}
+
+	if err := writeKeyFile(path, private, 0600); err != nil {
+		return err
+	}
+
+	return writeKeyFile(publicPath, public, 0644)
+}
+
+// writeKeyShares splits a key into shares and writes each to path.share<n>.
+// The key itself is never written. If the key is a private key, its public
+// key is written to path.pub.
+func writeKeyShares(log *logrus.Logger, path, keyType string, key []byte, n, k int, public []byte) error {
+	keyShares, err := lib.SplitKey(keyType, key, n, k)
+	if err != nil {
+		return err
+	}
+
+	// Check all files first, so no incomplete set of shares is written.
+	var paths []string
+	for i := range keyShares {
+		paths = append(paths, fmt.Sprintf("%s.share%d", path, i+1))
+	}
+	if public != nil {
+		paths = append(paths, path+".pub")
+	}
+
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("%s already exists", p)
+		}
+	}
+
+	for i, s := range keyShares {
+		if err := writeKeyFile(paths[i], s.Marshal(), 0600); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Wrote %d key shares to %s.share1 to %s.share%d. Any %d of them rebuild the key", n, path, path, n, k)
+
+	if public != nil {
+		if err := writeKeyFile(path+".pub", public, 0644); err != nil {
+			return err
+		}
+
+		log.Infof("Wrote public key to %s", path+".pub")
+	}
+
+	return nil
+}
+
+// writeKeyFile writes a key to a new file. Existing files are never
+// overwritten.
+func writeKeyFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("Unable to create key file: %s", err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("Unable to write key file: %s", err)
+	}
+
+	return f.Close()
+}
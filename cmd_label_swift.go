@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdLabelSwift defines a cli command to change the labels of an image
+// stored in Swift.
+var cmdLabelSwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionLabelSwift,
+	Category: "label",
+}
+
+func init() {
+	cmdLabelSwift.Flags = append(cmdLabelSwift.Flags, labelFlags...)
+	cmdLabelSwift.Flags = append(cmdLabelSwift.Flags, openStackFlags...)
+	cmdLabelSwift.Flags = append(cmdLabelSwift.Flags, cryptFlags...)
+	cmdLabelSwift.Flags = append(cmdLabelSwift.Flags, kmsFlags...)
+	cmdLabelSwift.Flags = append(cmdLabelSwift.Flags, signFlags...)
+	cmdLabelSwift.Flags = append(cmdLabelSwift.Flags, retryFlags...)
+}
+
+// actionLabelSwift implements the actions to change the labels of an image
+// stored in Swift. The labels are stored in the manifest of the image and
+// in the metadata of its meta object.
+func actionLabelSwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// A name is required.
+	objectName := ctx.String("object-name")
+	if objectName == "" {
+		return fmt.Errorf("must specify --object-name")
+	}
+	log.Debugf("Object name is: %s", objectName)
+
+	// A storage container name is required.
+	storageContainerName := ctx.String("storage-container")
+	if storageContainerName == "" {
+		return fmt.Errorf("must specify --storage-container")
+	}
+	log.Debugf("Storage container name is: %s", storageContainerName)
+
+	set, err := lib.ParseLabels(ctx.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+
+	// Labels to remove are parsed like labels without a value.
+	remove, err := lib.ParseLabels(ctx.StringSlice("remove-label"))
+	if err != nil {
+		return err
+	}
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	cryptOpts, err := newCryptOpts(ctx, log, "", false, swiftClient)
+	if err != nil {
+		return err
+	}
+
+	objectName, err = resolveImageName(ctx, log, swiftClient, storageContainerName, objectName, cryptOpts)
+	if err != nil {
+		return err
+	}
+
+	labels, err := lib.SwiftGetLabels(swiftClient, storageContainerName, objectName)
+	if err != nil {
+		return fmt.Errorf("Unable to get labels of %s: %s", objectName, err)
+	}
+
+	// Without changes, the labels are only printed.
+	if len(set) == 0 && len(remove) == 0 {
+		fmt.Println(labels)
+		return nil
+	}
+
+	for key, value := range set {
+		labels[key] = value
+	}
+
+	for key := range remove {
+		delete(labels, key)
+	}
+
+	// The manifest is covered by the signature of a signed image, so the
+	// image has to be signed again. Only a valid signature is renewed.
+	signed, err := lib.SwiftObjectExists(swiftClient, storageContainerName, objectName+lib.SwiftSignatureSuffix)
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := readSigningKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	verifyKeys, err := readVerifyKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if signed && (signingKey == nil || verifyKeys == nil) {
+		return fmt.Errorf("%s is signed. Use --verify-key and --sign-key to sign it again", objectName)
+	}
+
+	var signature *lib.ImageSignature
+	if signed {
+		log.Infof("Verifying signature of %s", objectName)
+		signature, err = lib.SwiftVerifyImage(swiftClient, storageContainerName, objectName, verifyKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest, err := readImageManifest(log, swiftClient, storageContainerName, objectName, cryptOpts, signature)
+	if err != nil {
+		return err
+	}
+
+	if manifest != nil {
+		manifest.Labels = labels
+		if len(labels) == 0 {
+			manifest.Labels = nil
+		}
+
+		manifestOpts := lib.SwiftManifestOpts{
+			StorageContainer: storageContainerName,
+			ObjectName:       objectName,
+			Manifest:         *manifest,
+		}
+
+		if manifest.Encrypted {
+			manifestOpts.CryptOpts = &cryptOpts
+		}
+
+		log.Infof("Updating manifest of %s", objectName)
+		manifestSigned, err := lib.SwiftWriteManifest(swiftClient, manifestOpts)
+		if err != nil {
+			return err
+		}
+
+		if signature != nil {
+			var signedObjects []lib.SignedObject
+			for _, o := range signature.Objects {
+				if o.Name != manifestSigned.Name {
+					signedObjects = append(signedObjects, o)
+				}
+			}
+			signedObjects = append(signedObjects, *manifestSigned)
+
+			signOpts := lib.SwiftSignOpts{
+				StorageContainer: storageContainerName,
+				ObjectName:       objectName,
+				Objects:          signedObjects,
+				Key:              signingKey,
+			}
+
+			log.Infof("Signing %s", objectName)
+			if err := lib.SwiftSignImage(swiftClient, signOpts); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := lib.SwiftSetLabels(swiftClient, storageContainerName, objectName, labels); err != nil {
+		return err
+	}
+
+	log.Infof("Labels of %s are now: %s", objectName, labels)
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdListSwift defines a cli command to list the images stored in a Swift
+// container.
+var cmdListSwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionListSwift,
+	Category: "list",
+}
+
+func init() {
+	cmdListSwift.Flags = append(cmdListSwift.Flags, listFlags...)
+	cmdListSwift.Flags = append(cmdListSwift.Flags, openStackFlags...)
+	cmdListSwift.Flags = append(cmdListSwift.Flags, cryptFlags...)
+	cmdListSwift.Flags = append(cmdListSwift.Flags, kmsFlags...)
+	cmdListSwift.Flags = append(cmdListSwift.Flags, retryFlags...)
+}
+
+// listEntry is an image as it is printed by list. Object is the opaque name
+// of an image whose logical name was read from the index.
+type listEntry struct {
+	Name        string     `json:"name"`
+	Object      string     `json:"object,omitempty"`
+	Created     time.Time  `json:"created"`
+	Size        int64      `json:"size"`
+	Fingerprint string     `json:"fingerprint"`
+	Encrypted   bool       `json:"encrypted"`
+	Signed      bool       `json:"signed"`
+	Versions    int        `json:"versions"`
+	Labels      lib.Labels `json:"labels,omitempty"`
+}
+
+// actionListSwift implements the actions to print a catalog of the images
+// in a Swift container.
+func actionListSwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// A storage container name is required.
+	storageContainerName := ctx.String("storage-container")
+	if storageContainerName == "" {
+		return fmt.Errorf("must specify --storage-container")
+	}
+	log.Debugf("Storage container name is: %s", storageContainerName)
+
+	format := ctx.String("format")
+	switch format {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("Unknown format: %s", format)
+	}
+
+	var since time.Time
+	if v := ctx.String("since"); v != "" {
+		var err error
+		since, err = parseSince(v, time.Now())
+		if err != nil {
+			return err
+		}
+		log.Debugf("Listing images created since %s", since)
+	}
+
+	tmpl, err := newNameTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	selector, err := newSelector(ctx)
+	if err != nil {
+		return err
+	}
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	// Only the images an --object-name-template built and --selector
+	// matches are considered.
+	prefix := ctx.String("prefix")
+	if prefix == "" && tmpl != nil {
+		prefix = tmpl.Prefix()
+	}
+
+	images, err := listImages(ctx, log, swiftClient, storageContainerName, prefix, tmpl, selector)
+	if err != nil {
+		return err
+	}
+
+	var entries []listEntry
+	for _, image := range images {
+		if image.Created.Before(since) {
+			continue
+		}
+
+		var object string
+		if image.LogicalName != "" {
+			object = image.Name
+		}
+
+		entries = append(entries, listEntry{
+			Name:        image.DisplayName(),
+			Object:      object,
+			Created:     image.Created,
+			Size:        image.Size,
+			Fingerprint: image.Fingerprint,
+			Encrypted:   image.Encrypted,
+			Signed:      image.Signed,
+			Versions:    image.Versions,
+			Labels:      image.Labels,
+		})
+	}
+	log.Debugf("Found %d images", len(entries))
+
+	switch format {
+	case "json":
+		return writeListJSON(os.Stdout, entries)
+	case "csv":
+		return writeListCSV(os.Stdout, entries)
+	}
+
+	return writeListTable(os.Stdout, entries)
+}
+
+// parseSince parses a date, a time or a duration before now. Durations may
+// be given in days, such as 7d.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+
+	if d, err := parseAge(v); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid --since: %s", v)
+}
+
+// parseAge parses a duration which may also be given in days, such as 7d.
+func parseAge(v string) (time.Duration, error) {
+	if strings.HasSuffix(v, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid duration: %s", v)
+	}
+
+	return d, nil
+}
+
+// writeListTable prints the entries as a table. The OBJECT column is only
+// shown if an entry has an opaque name.
+func writeListTable(w io.Writer, entries []listEntry) error {
+	var objects bool
+	for _, e := range entries {
+		if e.Object != "" {
+			objects = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if objects {
+		fmt.Fprint(tw, "NAME\tOBJECT\t")
+	} else {
+		fmt.Fprint(tw, "NAME\t")
+	}
+	fmt.Fprintln(tw, "CREATED\tSIZE\tFINGERPRINT\tENCRYPTED\tSIGNED\tVERSIONS\tLABELS")
+
+	for _, e := range entries {
+		fingerprint := e.Fingerprint
+		if len(fingerprint) > 12 {
+			fingerprint = fingerprint[:12]
+		}
+
+		if objects {
+			fmt.Fprintf(tw, "%s\t%s\t", e.Name, e.Object)
+		} else {
+			fmt.Fprintf(tw, "%s\t", e.Name)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			e.Created.Local().Format("2006-01-02 15:04"), formatSize(e.Size),
+			fingerprint, yesNo(e.Encrypted), yesNo(e.Signed), e.Versions, e.Labels)
+	}
+
+	return tw.Flush()
+}
+
+func writeListJSON(w io.Writer, entries []listEntry) error {
+	// An empty list is printed as [] rather than null.
+	if entries == nil {
+		entries = []listEntry{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func writeListCSV(w io.Writer, entries []listEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "created", "size", "fingerprint", "encrypted", "signed", "versions", "labels", "object"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Name,
+			e.Created.UTC().Format(time.RFC3339),
+			strconv.FormatInt(e.Size, 10),
+			e.Fingerprint,
+			strconv.FormatBool(e.Encrypted),
+			strconv.FormatBool(e.Signed),
+			strconv.Itoa(e.Versions),
+			e.Labels.String(),
+			e.Object,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatSize formats a number of bytes with a binary unit.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
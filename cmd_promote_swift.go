@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdPromoteSwift defines a cli command to promote an image from one Swift
+// container to another.
+var cmdPromoteSwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionPromoteSwift,
+	Category: "promote",
+}
+
+func init() {
+	cmdPromoteSwift.Flags = append(cmdPromoteSwift.Flags, promoteFlags...)
+	cmdPromoteSwift.Flags = append(cmdPromoteSwift.Flags, openStackFlags...)
+	cmdPromoteSwift.Flags = append(cmdPromoteSwift.Flags, signFlags...)
+	cmdPromoteSwift.Flags = append(cmdPromoteSwift.Flags, retryFlags...)
+}
+
+// actionPromoteSwift implements the actions to copy an exported image
+// between two Swift containers without downloading it.
+func actionPromoteSwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// An object name is required.
+	objectName := ctx.String("object-name")
+	if objectName == "" {
+		return fmt.Errorf("must specify --object-name")
+	}
+	log.Debugf("Object name is: %s", objectName)
+
+	// Source and destination storage containers are required.
+	fromContainerName := ctx.String("from")
+	if fromContainerName == "" {
+		return fmt.Errorf("must specify --from")
+	}
+	log.Debugf("Source storage container name is: %s", fromContainerName)
+
+	toContainerName := ctx.String("to")
+	if toContainerName == "" {
+		return fmt.Errorf("must specify --to")
+	}
+	log.Debugf("Destination storage container name is: %s", toContainerName)
+
+	if fromContainerName == toContainerName {
+		return fmt.Errorf("--from and --to must be different")
+	}
+
+	createStorageContainer := ctx.Bool("create-storage-container")
+	log.Debugf("Create storage container if it doesn't exist: %t", createStorageContainer)
+
+	archive := ctx.Bool("archive")
+	log.Debugf("Images will be archived: %t", archive)
+
+	verifyKeys, err := readVerifyKeys(ctx)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Signature will be checked: %t", verifyKeys != nil)
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	// See if the destination storage container exists.
+	// Configure the container to archive, if requested.
+	log.Debug("Configuring Swift container")
+	err = lib.SwiftCreateContainer(swiftClient, toContainerName, createStorageContainer, archive)
+	if err != nil {
+		return err
+	}
+
+	// Resolve a @latest pointer to the export it refers to. Pointers with
+	// obfuscated names have no suffix, so every object is checked.
+	pointerName := ""
+	target, err := lib.SwiftResolvePointer(swiftClient, fromContainerName, objectName)
+	if _, ok := err.(lib.ErrObjectDoesNotExist); !ok && err != nil {
+		return fmt.Errorf("Unable to resolve %s: %s", objectName, err)
+	}
+
+	if err == nil && target != objectName {
+		log.Infof("Resolved %s to %s", objectName, target)
+		pointerName = objectName
+		objectName = target
+	}
+
+	promoteOpts := lib.SwiftPromoteOpts{
+		ObjectName:      objectName,
+		FromContainer:   fromContainerName,
+		ToContainer:     toContainerName,
+		RequireVerified: ctx.Bool("require-verified"),
+		VerifyKeys:      verifyKeys,
+	}
+	log.Debugf("Swift promoteOpts: %#v", promoteOpts)
+
+	log.Infof("Promoting %s from %s to %s", objectName, fromContainerName, toContainerName)
+	promoteResult, err := lib.SwiftPromoteImage(swiftClient, promoteOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to promote %s: %s", objectName, err)
+	}
+	log.Debugf("Swift promoteResult: %#v", promoteResult)
+
+	// Point the destination's @latest pointer to the promoted image.
+	if pointerName != "" {
+		pointerOpts := lib.SwiftPointerOpts{
+			StorageContainer: toContainerName,
+			PointerName:      pointerName,
+			Target:           objectName,
+		}
+		log.Debugf("Swift pointerOpts: %#v", pointerOpts)
+
+		log.Infof("Pointing %s to %s in %s", pointerName, objectName, toContainerName)
+		if err := lib.SwiftUpdatePointer(swiftClient, pointerOpts); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Successfully promoted %s to %s", objectName, toContainerName)
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdPruneSwift defines a cli command to remove old exports from a Swift
+// container.
+var cmdPruneSwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionPruneSwift,
+	Category: "prune",
+}
+
+func init() {
+	cmdPruneSwift.Flags = append(cmdPruneSwift.Flags, pruneFlags...)
+	cmdPruneSwift.Flags = append(cmdPruneSwift.Flags, openStackFlags...)
+	cmdPruneSwift.Flags = append(cmdPruneSwift.Flags, cryptFlags...)
+	cmdPruneSwift.Flags = append(cmdPruneSwift.Flags, kmsFlags...)
+	cmdPruneSwift.Flags = append(cmdPruneSwift.Flags, retryFlags...)
+}
+
+// actionPruneSwift implements the actions to apply a retention policy to
+// the exports in a Swift container.
+func actionPruneSwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// A storage container name is required.
+	storageContainerName := ctx.String("storage-container")
+	if storageContainerName == "" {
+		return fmt.Errorf("must specify --storage-container")
+	}
+	log.Debugf("Storage container name is: %s", storageContainerName)
+
+	policy, err := newRetentionPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Retention policy: %#v", policy)
+
+	dryRun := ctx.Bool("dry-run")
+
+	tmpl, err := newNameTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	selector, err := newSelector(ctx)
+	if err != nil {
+		return err
+	}
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	// Only the images an --object-name-template built and --selector
+	// matches are considered.
+	prefix := ctx.String("prefix")
+	if prefix == "" && tmpl != nil {
+		prefix = tmpl.Prefix()
+	}
+
+	// With --obfuscate-names, obfuscated exports are grouped by the logical
+	// names in the index. Removing them removes their index entries.
+	images, err := listImages(ctx, log, swiftClient, storageContainerName, prefix, tmpl, selector)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Found %d images", len(images))
+
+	// Exports which @latest pointers refer to or which are labelled
+	// do-not-delete are always kept.
+	targets, err := lib.SwiftPointerTargets(swiftClient, storageContainerName)
+	if err != nil {
+		return err
+	}
+
+	protected := map[string]string{}
+	for target := range targets {
+		protected[target] = "latest"
+	}
+
+	for _, image := range images {
+		if _, ok := image.Labels[lib.LabelDoNotDelete]; ok {
+			protected[image.Name] = lib.LabelDoNotDelete
+		}
+	}
+
+	decisions := lib.ApplyRetention(images, policy, protected, tmpl)
+	if err := writePruneTable(os.Stdout, decisions, dryRun); err != nil {
+		return err
+	}
+
+	var kept, removed int64
+	var remove []lib.RetentionDecision
+	for _, d := range decisions {
+		if d.Keep {
+			kept += d.Image.Size
+		} else {
+			removed += d.Image.Size
+			remove = append(remove, d)
+		}
+	}
+
+	if policy.MaxSize > 0 && kept > policy.MaxSize {
+		log.Warnf("The kept exports use %s, which is more than --max-size. "+
+			"The newest export of each source is never removed", formatSize(kept))
+	}
+
+	if dryRun {
+		log.Infof("Dry run: %d exports (%s) would be removed", len(remove), formatSize(removed))
+		return nil
+	}
+
+	for _, d := range remove {
+		if d.Image.LogicalName != "" {
+			log.Infof("Removing %s (%s)", d.Image.LogicalName, d.Image.Name)
+		} else {
+			log.Infof("Removing %s", d.Image.Name)
+		}
+		result, err := lib.SwiftDeleteImage(swiftClient, storageContainerName, d.Image.Name)
+		if err != nil {
+			return fmt.Errorf("Unable to remove %s: %s", d.Image.Name, err)
+		}
+		log.Debugf("Swift deleteResult: %#v", result)
+
+		if len(result.SharedSegments) > 0 {
+			log.Infof("Kept %d segments of %s which promoted copies use",
+				len(result.SharedSegments), d.Image.DisplayName())
+		}
+	}
+
+	log.Infof("Successfully removed %d exports (%s)", len(remove), formatSize(removed))
+	return nil
+}
+
+// newRetentionPolicy creates a retention policy from the keep flags. At
+// least one rule or --max-size is required, so prune never removes every
+// export by accident.
+func newRetentionPolicy(ctx *cli.Context) (lib.RetentionPolicy, error) {
+	policy := lib.RetentionPolicy{
+		KeepLast:    ctx.Int("keep-last"),
+		KeepDaily:   ctx.Int("keep-daily"),
+		KeepWeekly:  ctx.Int("keep-weekly"),
+		KeepMonthly: ctx.Int("keep-monthly"),
+	}
+
+	for name, n := range map[string]int{
+		"keep-last":    policy.KeepLast,
+		"keep-daily":   policy.KeepDaily,
+		"keep-weekly":  policy.KeepWeekly,
+		"keep-monthly": policy.KeepMonthly,
+	} {
+		if n < 0 {
+			return policy, fmt.Errorf("Invalid --%s: %d", name, n)
+		}
+	}
+
+	if v := ctx.String("keep-within"); v != "" {
+		d, err := parseAge(v)
+		if err != nil {
+			return policy, fmt.Errorf("Invalid --keep-within: %s", v)
+		}
+		policy.KeepWithin = d
+	}
+
+	if v := ctx.String("max-size"); v != "" {
+		size, err := parseSize(v)
+		if err != nil || size <= 0 {
+			return policy, fmt.Errorf("Invalid --max-size: %s", v)
+		}
+		policy.MaxSize = size
+	}
+
+	if !policy.HasKeepRules() && policy.MaxSize == 0 {
+		return policy, fmt.Errorf("must specify a --keep rule or --max-size")
+	}
+
+	return policy, nil
+}
+
+// parseSize parses a size in bytes with an optional binary unit, such as
+// 500G or 1.5TiB.
+func parseSize(v string) (int64, error) {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(v)), "B"), "I")
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGTPE"); i >= 0 && i == len(s)-1 {
+		exp := strings.IndexByte("KMGTPE", s[i]) + 1
+		for ; exp > 0; exp-- {
+			multiplier *= 1024
+		}
+		s = strings.TrimSpace(s[:i])
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size: %s", v)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
+
+func writePruneTable(w io.Writer, decisions []lib.RetentionDecision, dryRun bool) error {
+	remove := "remove"
+	if dryRun {
+		remove = "would remove"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tNAME\tCREATED\tSIZE\tACTION\tREASON")
+	for _, d := range decisions {
+		action := "keep"
+		if !d.Keep {
+			action = remove
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Source, d.Image.DisplayName(),
+			d.Image.Created.Local().Format("2006-01-02 15:04"), formatSize(d.Image.Size),
+			action, strings.Join(d.Reasons, ", "))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ed25519"
+)
+
+// cmdRekeySwift defines a cli command to re-encrypt images stored in Swift
+// with new secrets.
+var cmdRekeySwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionRekeySwift,
+	Category: "rekey",
+}
+
+func init() {
+	cmdRekeySwift.Flags = append(cmdRekeySwift.Flags, rekeyFlags...)
+	cmdRekeySwift.Flags = append(cmdRekeySwift.Flags, cryptFlags...)
+	cmdRekeySwift.Flags = append(cmdRekeySwift.Flags, kmsFlags...)
+	cmdRekeySwift.Flags = append(cmdRekeySwift.Flags, signFlags...)
+	cmdRekeySwift.Flags = append(cmdRekeySwift.Flags, openStackFlags...)
+	cmdRekeySwift.Flags = append(cmdRekeySwift.Flags, retryFlags...)
+}
+
+// rekeyJob holds everything needed to rekey a single image.
+type rekeyJob struct {
+	swiftClient       *gophercloud.ServiceClient
+	storageContainer  string
+	segmentSize       int64
+	tmpDir            string
+	oldCrypt          lib.CryptOpts
+	newCrypt          lib.CryptOpts
+	legacy            bool
+	signingKey        ed25519.PrivateKey
+	verifyKeys        []ed25519.PublicKey
+	deleteOldSegments bool
+	log               *logrus.Logger
+
+	// pointers maps the pointers in the storage container to the images
+	// they refer to. Pointers to renamed images are updated.
+	pointers map[string]string
+}
+
+// actionRekeySwift implements the actions to re-encrypt images in Swift
+// without importing them into LXD.
+func actionRekeySwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// A storage container name is required.
+	storageContainerName := ctx.String("storage-container")
+	if storageContainerName == "" {
+		return fmt.Errorf("must specify --storage-container")
+	}
+	log.Debugf("Storage container name is: %s", storageContainerName)
+
+	objectNames := ctx.StringSlice("object-name")
+	prefix := ctx.String("prefix")
+	if len(objectNames) == 0 && prefix == "" {
+		return fmt.Errorf("must specify --object-name or --prefix")
+	}
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	// Read the old and the new secrets.
+	oldCrypt, err := newCryptOpts(ctx, log, "", false, swiftClient)
+	if err != nil {
+		return err
+	}
+
+	newCrypt, err := newCryptOpts(ctx, log, "new-", true, swiftClient)
+	if err != nil {
+		return err
+	}
+
+	if newCrypt.KeyManager != nil && newCrypt.KeyManager.KeyID() == "" {
+		return fmt.Errorf("--new-kms-key is required to encrypt with %s", newCrypt.KeyManager.Name())
+	}
+
+	if newCrypt.NeedsPassphrase() {
+		if newCrypt.Pass, err = newCrypt.PassFunc(); err != nil {
+			return err
+		}
+	}
+
+	signingKey, err := readSigningKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	verifyKeys, err := readVerifyKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Names given on the command line may be logical names of obfuscated
+	// images or @latest pointers.
+	for i, objectName := range objectNames {
+		objectNames[i], err = resolveImageName(ctx, log, swiftClient, storageContainerName, objectName, oldCrypt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if prefix != "" {
+		infos, err := lib.SwiftListObjectInfo(swiftClient, storageContainerName, prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			if !lib.SwiftIsImage(info.Name) {
+				continue
+			}
+
+			pointer, err := lib.SwiftIsPointer(swiftClient, storageContainerName, info)
+			if err != nil {
+				return err
+			}
+
+			if !pointer {
+				objectNames = append(objectNames, info.Name)
+			}
+		}
+	}
+
+	if len(objectNames) == 0 {
+		return fmt.Errorf("No images found with prefix %s", prefix)
+	}
+	log.Debugf("Images to rekey: %v", objectNames)
+
+	pointers, err := lib.SwiftListPointers(swiftClient, storageContainerName)
+	if err != nil {
+		return err
+	}
+
+	job := rekeyJob{
+		swiftClient:       swiftClient,
+		storageContainer:  storageContainerName,
+		segmentSize:       ctx.Int64("segment-size") * 1024 * 1024,
+		tmpDir:            ctx.String("tmpdir"),
+		oldCrypt:          oldCrypt,
+		newCrypt:          newCrypt,
+		legacy:            ctx.Bool("encrypt") || oldCrypt.Mode == lib.CryptModeOpenPGP,
+		signingKey:        signingKey,
+		verifyKeys:        verifyKeys,
+		deleteOldSegments: ctx.Bool("delete-old-segments"),
+		log:               log,
+		pointers:          pointers,
+	}
+
+	for _, objectName := range objectNames {
+		log.Infof("Rekeying %s", objectName)
+		if err := job.rekeyImage(objectName); err != nil {
+			return fmt.Errorf("Unable to rekey %s: %s", objectName, err)
+		}
+	}
+
+	log.Infof("Successfully rekeyed %d images", len(objectNames))
+	return nil
+}
+
+// rekeyImage re-encrypts the rootfs and meta objects of an image. The new
+// objects replace the old ones only once both have been uploaded, and the
+// old objects are restored if any of them cannot be replaced.
+//
+// Obfuscated names are derived from the secret, so an image with an
+// obfuscated name is moved to the name derived from the new secret. Its
+// index entry and those of the pointers which refer to it are encrypted
+// with the new secret and moved along with it.
+func (j rekeyJob) rekeyImage(objectName string) error {
+	names := []string{objectName}
+
+	rootfsObjectName := objectName + ".root"
+	rootfsObjectExists, err := lib.SwiftObjectExists(j.swiftClient, j.storageContainer, rootfsObjectName)
+	if err != nil {
+		return err
+	}
+
+	if rootfsObjectExists {
+		names = []string{rootfsObjectName, objectName}
+	}
+
+	// A signed image would no longer match its signature, so it has to be
+	// signed again. Its signature is checked first, and every object is
+	// checked against it while it is re-encrypted, so only the data which
+	// was signed is signed again.
+	signed, err := lib.SwiftObjectExists(j.swiftClient, j.storageContainer, objectName+lib.SwiftSignatureSuffix)
+	if err != nil {
+		return err
+	}
+
+	if signed && (j.signingKey == nil || j.verifyKeys == nil) {
+		return fmt.Errorf("%s is signed. Use --verify-key and --sign-key to sign it again", objectName)
+	}
+
+	var signature *lib.ImageSignature
+	if signed || j.verifyKeys != nil {
+		j.log.Debugf("Verifying signature of %s", objectName)
+		signature, err = lib.SwiftVerifyImage(j.swiftClient, j.storageContainer, objectName, j.verifyKeys)
+		if err != nil {
+			return err
+		}
+
+		if err := lib.SwiftCheckSignedObjects(j.swiftClient, j.storageContainer, objectName, signature); err != nil {
+			return err
+		}
+	}
+
+	image, err := j.renameObject(objectName)
+	if err != nil {
+		return err
+	}
+
+	newObjectName := image.newName
+	newNames := make([]string, len(names))
+	for i, name := range names {
+		newNames[i] = newObjectName + strings.TrimPrefix(name, objectName)
+	}
+
+	if newObjectName != objectName {
+		j.log.Infof("Moving %s to %s", objectName, newObjectName)
+	}
+
+	var pointers []*rekeyRename
+	for pointerName, target := range j.pointers {
+		if target != objectName {
+			continue
+		}
+
+		pointer, err := j.renameObject(pointerName)
+		if err != nil {
+			return err
+		}
+
+		// Pointers which are not obfuscated only change with the image.
+		if pointer.entry != nil || newObjectName != objectName {
+			pointers = append(pointers, pointer)
+		}
+	}
+
+	// abort discards uploads which have not been committed.
+	var results []*lib.SwiftRekeyResult
+	abort := func(pending []*lib.SwiftRekeyResult) {
+		for _, result := range pending {
+			result.Upload.Abort()
+		}
+	}
+
+	for i, name := range names {
+		expected, err := signedObject(signature, name)
+		if err != nil {
+			abort(results)
+			return err
+		}
+
+		rekeyOpts := lib.SwiftRekeyOpts{
+			StorageContainer: j.storageContainer,
+			ObjectName:       name,
+			NewObjectName:    newNames[i],
+			SegmentSize:      j.segmentSize,
+			TempDir:          j.tmpDir,
+			OldCrypt:         j.oldCrypt,
+			NewCrypt:         j.newCrypt,
+			Legacy:           j.legacy,
+			Signed:           expected,
+		}
+
+		j.log.Debugf("Re-encrypting %s", name)
+		result, err := lib.SwiftRekeyObject(j.swiftClient, rekeyOpts)
+		if err != nil {
+			abort(results)
+			return err
+		}
+
+		results = append(results, result)
+	}
+
+	manifest, err := j.readManifest(objectName, signature)
+	if err != nil {
+		abort(results)
+		return err
+	}
+
+	// Each object of the image, its index entry and its pointers move to
+	// the names derived from the new secret.
+	type move struct {
+		from, to string
+	}
+
+	var moves []move
+	for i := range names {
+		moves = append(moves, move{names[i], newNames[i]})
+	}
+
+	for _, suffix := range []string{lib.SwiftManifestSuffix, lib.SwiftSignatureSuffix} {
+		moves = append(moves, move{objectName + suffix, newObjectName + suffix})
+	}
+
+	moves = append(moves, move{lib.SwiftIndexPrefix + objectName, lib.SwiftIndexPrefix + newObjectName})
+	for _, pointer := range pointers {
+		moves = append(moves, move{pointer.oldName, pointer.newName},
+			move{lib.SwiftIndexPrefix + pointer.oldName, lib.SwiftIndexPrefix + pointer.newName})
+	}
+
+	// Keep copies of everything which is replaced, so the image is never
+	// left with objects encrypted with different secrets. Objects which do
+	// not exist yet are deleted again if rekeying fails.
+	var backupNames, moved []string
+	seen := map[string]bool{}
+	for _, m := range moves {
+		for _, name := range []string{m.from, m.to} {
+			if !seen[name] {
+				seen[name] = true
+				backupNames = append(backupNames, name)
+			}
+		}
+
+		if m.from != m.to {
+			moved = append(moved, m.from)
+		}
+	}
+
+	backup, err := lib.SwiftBackupObjects(j.swiftClient, j.storageContainer, backupNames)
+	if err != nil {
+		abort(results)
+		return err
+	}
+
+	// rollback restores the old objects and deletes the new segments which
+	// are no longer used.
+	rollback := func(err error) error {
+		if restoreErr := backup.Restore(); restoreErr != nil {
+			return fmt.Errorf("%s. Unable to restore the old objects: %s", err, restoreErr)
+		}
+		backup.Discard()
+
+		for i, result := range results {
+			segmentNames := []string{names[i], newNames[i]}
+			lib.SwiftDeleteUnusedSegments(j.swiftClient, j.storageContainer, segmentNames, result.Upload.Segments())
+		}
+
+		return err
+	}
+
+	// Commit the rootfs first and then the meta object. The manifest and
+	// the signature are only written once both have been replaced.
+	var signedObjects []lib.SignedObject
+	for i, result := range results {
+		j.log.Debugf("Replacing %s", newNames[i])
+		if _, err := result.Upload.Commit(); err != nil {
+			return rollback(err)
+		}
+
+		signedObjects = append(signedObjects, lib.SignedObject{
+			Name:   newNames[i],
+			Size:   result.Upload.Size,
+			SHA256: result.Upload.SHA256,
+		})
+	}
+
+	if manifest != nil {
+		for i := range manifest.Objects {
+			manifest.Objects[i].Name = newObjectName + strings.TrimPrefix(manifest.Objects[i].Name, objectName)
+		}
+		manifest.Image = newObjectName
+
+		j.log.Debugf("Updating manifest of %s", newObjectName)
+		signed, err := j.writeManifest(newObjectName, *manifest, signedObjects)
+		if err != nil {
+			return rollback(err)
+		}
+
+		signedObjects = append(signedObjects, *signed)
+	}
+
+	if signature != nil {
+		signOpts := lib.SwiftSignOpts{
+			StorageContainer: j.storageContainer,
+			ObjectName:       newObjectName,
+			Objects:          signedObjects,
+			Key:              j.signingKey,
+		}
+
+		j.log.Debugf("Signing %s", newObjectName)
+		if err := lib.SwiftSignImage(j.swiftClient, signOpts); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if err := j.moveIndexEntry(image); err != nil {
+		return rollback(err)
+	}
+
+	for _, pointer := range pointers {
+		pointerOpts := lib.SwiftPointerOpts{
+			StorageContainer: j.storageContainer,
+			PointerName:      pointer.newName,
+			Target:           newObjectName,
+		}
+
+		j.log.Debugf("Pointing %s to %s", pointer.newName, newObjectName)
+		if err := lib.SwiftUpdatePointer(j.swiftClient, pointerOpts); err != nil {
+			return rollback(err)
+		}
+
+		if err := j.moveIndexEntry(pointer); err != nil {
+			return rollback(err)
+		}
+	}
+
+	// The objects under the old names are removed once everything has
+	// been moved.
+	if err := lib.SwiftDeleteObjects(j.swiftClient, j.storageContainer, moved); err != nil {
+		return rollback(err)
+	}
+
+	if err := backup.Discard(); err != nil {
+		j.log.Warnf("Unable to delete the copies of the old objects: %s", err)
+	}
+
+	for _, pointer := range pointers {
+		delete(j.pointers, pointer.oldName)
+		j.pointers[pointer.newName] = newObjectName
+	}
+
+	if !j.deleteOldSegments {
+		return nil
+	}
+
+	// Segments of archived versions or promoted copies are kept.
+	for i, result := range results {
+		segmentNames := []string{names[i], newNames[i]}
+		deleteResult, err := lib.SwiftDeleteUnusedSegments(j.swiftClient, j.storageContainer, segmentNames, result.OldSegments)
+		if err != nil {
+			return err
+		}
+
+		j.log.Debugf("Deleted %d old segments of %s", len(deleteResult.Segments), names[i])
+		if len(deleteResult.SharedSegments) > 0 {
+			j.log.Infof("Kept %d old segments of %s which are still in use", len(deleteResult.SharedSegments), names[i])
+		}
+	}
+
+	return nil
+}
+
+// rekeyRename is an object whose name may be obfuscated. entry is its index
+// entry, or nil if its name is not obfuscated.
+type rekeyRename struct {
+	oldName string
+	newName string
+	entry   *lib.IndexEntry
+}
+
+// renameObject determines the name an object is stored under once it is
+// rekeyed. Only objects with an index entry have obfuscated names.
+func (j rekeyJob) renameObject(objectName string) (*rekeyRename, error) {
+	rename := &rekeyRename{
+		oldName: objectName,
+		newName: objectName,
+	}
+
+	entry, err := lib.SwiftReadIndexEntry(j.swiftClient, j.storageContainer, objectName, j.oldCrypt)
+	if _, ok := err.(lib.ErrObjectDoesNotExist); ok {
+		return rename, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Object != objectName {
+		return nil, fmt.Errorf("Index entry %s belongs to %s", objectName, entry.Object)
+	}
+
+	rename.entry = entry
+	rename.newName, err = lib.ObfuscateName(entry.Name, j.newCrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	return rename, nil
+}
+
+// moveIndexEntry stores the index entry of a renamed object under its new
+// name, encrypted with the new secret.
+func (j rekeyJob) moveIndexEntry(rename *rekeyRename) error {
+	if rename.entry == nil {
+		return nil
+	}
+
+	entry := *rename.entry
+	entry.Object = rename.newName
+
+	j.log.Debugf("Updating index entry of %s", entry.Name)
+	return lib.SwiftAddIndexEntry(j.swiftClient, j.storageContainer, entry, j.newCrypt)
+}
+
+// readManifest reads the manifest of an image with the old secrets. nil is
+// returned if the image has no manifest.
+func (j rekeyJob) readManifest(objectName string, signature *lib.ImageSignature) (*lib.Manifest, error) {
+	name := objectName + lib.SwiftManifestSuffix
+	var signed *lib.SignedObject
+	if signature != nil {
+		signed = signature.Object(name)
+	}
+
+	manifest, err := lib.SwiftReadManifest(j.swiftClient, j.storageContainer, objectName, j.oldCrypt, signed)
+	if _, ok := err.(lib.ErrObjectDoesNotExist); ok && signed == nil {
+		return nil, nil
+	}
+
+	return manifest, err
+}
+
+// writeManifest updates the manifest of an image to describe the rekeyed
+// objects and stores it. An encrypted manifest is encrypted with the new
+// secrets.
+func (j rekeyJob) writeManifest(objectName string, manifest lib.Manifest, objects []lib.SignedObject) (*lib.SignedObject, error) {
+	for i := range manifest.Objects {
+		for _, o := range objects {
+			if manifest.Objects[i].Name == o.Name {
+				manifest.Objects[i].Size = o.Size
+				manifest.Objects[i].SHA256 = o.SHA256
+			}
+		}
+	}
+	manifest.Encryption = j.newCrypt.Scheme()
+
+	manifestOpts := lib.SwiftManifestOpts{
+		StorageContainer: j.storageContainer,
+		ObjectName:       objectName,
+		Manifest:         manifest,
+	}
+
+	if manifest.Encrypted {
+		manifestOpts.CryptOpts = &j.newCrypt
+	}
+
+	return lib.SwiftWriteManifest(j.swiftClient, manifestOpts)
+}
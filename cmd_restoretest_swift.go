@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdRestoreTestSwift defines a cli command to test that an image stored in
+// Swift can be restored.
+var cmdRestoreTestSwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionRestoreTestSwift,
+	Category: "restore-test",
+}
+
+func init() {
+	cmdRestoreTestSwift.Flags = append(cmdRestoreTestSwift.Flags, restoreTestFlags...)
+	cmdRestoreTestSwift.Flags = append(cmdRestoreTestSwift.Flags, openStackFlags...)
+	cmdRestoreTestSwift.Flags = append(cmdRestoreTestSwift.Flags, cryptFlags...)
+	cmdRestoreTestSwift.Flags = append(cmdRestoreTestSwift.Flags, kmsFlags...)
+	cmdRestoreTestSwift.Flags = append(cmdRestoreTestSwift.Flags, signFlags...)
+	cmdRestoreTestSwift.Flags = append(cmdRestoreTestSwift.Flags, retryFlags...)
+}
+
+// restoreTestStep is a step of a restore test as it is reported.
+type restoreTestStep struct {
+	Name     string
+	Detail   string
+	Err      error
+	Duration time.Duration
+}
+
+// actionRestoreTestSwift implements the actions to test that an image
+// stored in Swift can be restored. The image is imported under a temporary
+// alias and an ephemeral container is launched from it. Once the container
+// is running, a command can be run in it. The container and the image are
+// always removed again.
+func actionRestoreTestSwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// A name is required.
+	objectName := ctx.String("object-name")
+	if objectName == "" {
+		return fmt.Errorf("must specify --object-name")
+	}
+	log.Debugf("Object name is: %s", objectName)
+
+	// A storage container name is required.
+	storageContainerName := ctx.String("storage-container")
+	if storageContainerName == "" {
+		return fmt.Errorf("must specify --storage-container")
+	}
+	log.Debugf("Storage container name is: %s", storageContainerName)
+
+	lxdConfigDirectory := ctx.String("lxd-config-directory")
+	log.Debugf("LXD config directory is: %s", lxdConfigDirectory)
+
+	if ctx.IsSet("exit-code") && ctx.String("exec") == "" {
+		return fmt.Errorf("--exit-code requires --exec")
+	}
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	cryptOpts, err := newCryptOpts(ctx, log, "", false, swiftClient)
+	if err != nil {
+		return err
+	}
+
+	objectName, err = resolveImageName(ctx, log, swiftClient, storageContainerName, objectName, cryptOpts)
+	if err != nil {
+		return err
+	}
+
+	// Create an LXD client.
+	lxdConfig, err := newLXDConfig(lxdConfigDirectory, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to get LXD configuration: %s", err)
+	}
+
+	lxdConfig.RemoteName = ctx.String("remote")
+	if lxdConfig.RemoteName == "" {
+		lxdConfig.RemoteName = lxdConfig.Config.DefaultRemote
+	}
+	log.Debugf("LXD Remote: %s", lxdConfig.RemoteName)
+
+	// The alias of the image and the name of the container are unique, so
+	// tests can run side by side.
+	testName := fmt.Sprintf("limbo-restore-test-%x", time.Now().UnixNano())
+	log.Debugf("Test name is: %s", testName)
+
+	var steps []restoreTestStep
+	run := func(name string, step func() (string, error)) error {
+		start := time.Now()
+		detail, err := step()
+		steps = append(steps, restoreTestStep{
+			Name:     name,
+			Detail:   detail,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+
+		return err
+	}
+
+	var fingerprint string
+	err = run("import", func() (string, error) {
+		importResult, err := importSwiftImage(ctx, log, swiftClient, lxdConfig, storageContainerName, objectName, testName, nil, cryptOpts)
+		if importResult != nil {
+			fingerprint = importResult.Fingerprint
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		return "fingerprint " + fingerprint, nil
+	})
+
+	if err == nil {
+		err = run("launch", func() (string, error) {
+			launchOpts := lib.LXDLaunchOpts{
+				Name:        testName,
+				Fingerprint: fingerprint,
+				Profiles:    ctx.StringSlice("profile"),
+				Timeout:     ctx.Duration("timeout"),
+			}
+
+			log.Infof("Launching %s from %s", testName, objectName)
+			log.Debugf("LXD launchOpts: %#v", launchOpts)
+			if err := lib.LXDLaunchContainer(lxdConfig, launchOpts); err != nil {
+				return "", err
+			}
+
+			return "running", nil
+		})
+	}
+
+	if err == nil && ctx.String("exec") != "" {
+		run("exec", func() (string, error) {
+			execOpts := lib.LXDExecOpts{
+				Name:    testName,
+				Command: []string{"sh", "-c", ctx.String("exec")},
+				Stdout:  os.Stdout,
+				Stderr:  os.Stderr,
+			}
+
+			log.Infof("Running %q in %s", ctx.String("exec"), testName)
+			code, err := lib.LXDExecContainer(lxdConfig, execOpts)
+			if err != nil {
+				return "", err
+			}
+
+			detail := fmt.Sprintf("exit code %d", code)
+			if code != ctx.Int("exit-code") {
+				return detail, fmt.Errorf("expected exit code %d", ctx.Int("exit-code"))
+			}
+
+			return detail, nil
+		})
+	}
+
+	// Whatever happened, nothing of the test is left behind.
+	if fingerprint != "" {
+		run("teardown", func() (string, error) {
+			log.Infof("Removing %s", testName)
+			if err := lib.LXDDeleteContainer(lxdConfig, testName); err != nil {
+				return "", err
+			}
+
+			if err := lib.LXDDeleteImage(lxdConfig, fingerprint); err != nil {
+				return "", err
+			}
+
+			return "container and image removed", nil
+		})
+	}
+
+	failed := writeRestoreTestSteps(steps)
+	if failed > 0 {
+		return fmt.Errorf("Restore test of %s failed", objectName)
+	}
+
+	log.Infof("Restore test of %s passed", objectName)
+	return nil
+}
+
+// writeRestoreTestSteps prints the outcome of each step and returns the
+// number of failed steps.
+func writeRestoreTestSteps(steps []restoreTestStep) int {
+	var failed int
+	for _, step := range steps {
+		result := "ok"
+		var details []string
+		if step.Detail != "" {
+			details = append(details, step.Detail)
+		}
+
+		if step.Err != nil {
+			result = "FAILED"
+			details = append(details, step.Err.Error())
+			failed++
+		}
+
+		fmt.Printf("%-9s %-6s %6s  %s\n", step.Name+":", result,
+			step.Duration.Round(time.Second), strings.Join(details, ": "))
+	}
+
+	return failed
+}
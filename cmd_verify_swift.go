@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// cmdVerifySwift defines a cli command to check the integrity of an image
+// stored in Swift.
+var cmdVerifySwift = cli.Command{
+	Name:     "swift",
+	Usage:    "Swift Driver",
+	Action:   actionVerifySwift,
+	Category: "verify",
+}
+
+func init() {
+	cmdVerifySwift.Flags = append(cmdVerifySwift.Flags, verifyFlags...)
+	cmdVerifySwift.Flags = append(cmdVerifySwift.Flags, openStackFlags...)
+	cmdVerifySwift.Flags = append(cmdVerifySwift.Flags, cryptFlags...)
+	cmdVerifySwift.Flags = append(cmdVerifySwift.Flags, kmsFlags...)
+	cmdVerifySwift.Flags = append(cmdVerifySwift.Flags, signFlags...)
+	cmdVerifySwift.Flags = append(cmdVerifySwift.Flags, retryFlags...)
+}
+
+// actionVerifySwift implements the actions to check the integrity of an
+// image stored in Swift. The objects of the image are streamed and checked
+// against the manifest and the signature. If the image can be decrypted,
+// the files are checked and the LXD fingerprint is recalculated. Nothing is
+// imported into LXD.
+func actionVerifySwift(ctx *cli.Context) error {
+	log := logrus.New()
+	if ctx.GlobalBool("debug") {
+		log.Level = logrus.DebugLevel
+	}
+
+	// A name is required.
+	objectName := ctx.String("object-name")
+	if objectName == "" {
+		return fmt.Errorf("must specify --object-name")
+	}
+	log.Debugf("Object name is: %s", objectName)
+
+	// A storage container name is required.
+	storageContainerName := ctx.String("storage-container")
+	if storageContainerName == "" {
+		return fmt.Errorf("must specify --storage-container")
+	}
+	log.Debugf("Storage container name is: %s", storageContainerName)
+
+	retryOpts := newRetryOpts(ctx, log)
+	log.Debugf("Failed requests will be retried %d times", retryOpts.Retries)
+
+	// Get a Swift client.
+	log.Debug("Creating swift client")
+	swiftClient, err := newSwiftClient(ctx, retryOpts)
+	if err != nil {
+		return fmt.Errorf("Unable to create swift client: %s", err)
+	}
+
+	cryptOpts, err := newCryptOpts(ctx, log, "", false, swiftClient)
+	if err != nil {
+		return err
+	}
+
+	objectName, err = resolveImageName(ctx, log, swiftClient, storageContainerName, objectName, cryptOpts)
+	if err != nil {
+		return err
+	}
+
+	verifyKeys, err := readVerifyKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	var signature *lib.ImageSignature
+	if verifyKeys != nil {
+		log.Infof("Verifying signature of %s", objectName)
+		signature, err = lib.SwiftVerifyImage(swiftClient, storageContainerName, objectName, verifyKeys)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Image signature: %#v", signature)
+	} else {
+		signed, err := lib.SwiftObjectExists(swiftClient, storageContainerName, objectName+lib.SwiftSignatureSuffix)
+		if err != nil {
+			return err
+		}
+
+		if signed {
+			log.Warnf("%s is signed, but its signature is not checked without --verify-key", objectName)
+		}
+	}
+
+	manifest, err := readImageManifest(log, swiftClient, storageContainerName, objectName, cryptOpts, signature)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Image manifest: %#v", manifest)
+
+	if manifest == nil && signature == nil {
+		return fmt.Errorf("%s has neither a manifest nor a checked signature to verify against", objectName)
+	}
+
+	if manifest != nil && strings.HasPrefix(manifest.Encryption, lib.CryptModeOpenPGP) && cryptOpts.Mode != lib.CryptModeOpenPGP {
+		return fmt.Errorf("%s is encrypted in OpenPGP mode. Use --encrypt-mode openpgp", objectName)
+	}
+
+	// The content of encrypted objects is only checked if a key was given.
+	legacy := ctx.Bool("encrypt") || cryptOpts.Mode == lib.CryptModeOpenPGP
+	var decryptOpts *lib.CryptOpts
+	if legacy || cryptOpts.Pass != "" || cryptOpts.Key != nil || len(cryptOpts.Identities) > 0 ||
+		cryptOpts.KeyManager != nil || len(cryptOpts.PGPKeyring) > 0 {
+		decryptOpts = &cryptOpts
+	}
+
+	// The meta object comes first, as the LXD fingerprint is calculated
+	// over the meta file followed by the rootfs file.
+	roles := []string{lib.ManifestRoleMeta, lib.ManifestRoleRootfs}
+	names := map[string]string{
+		lib.ManifestRoleMeta:   objectName,
+		lib.ManifestRoleRootfs: objectName + ".root",
+	}
+
+	fingerprint := sha256.New()
+	var problems []string
+	checked := 0
+	verified := 0
+	for _, role := range roles {
+		name := names[role]
+		var manifestObject *lib.ManifestObject
+		if manifest != nil {
+			manifestObject = manifest.Object(role)
+			if manifestObject != nil {
+				name = manifestObject.Name
+			}
+		}
+
+		var signed *lib.SignedObject
+		if signature != nil {
+			signed = signature.Object(name)
+		}
+
+		exists, err := lib.SwiftObjectExists(swiftClient, storageContainerName, name)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			if manifestObject != nil || signed != nil {
+				problems = append(problems, fmt.Sprintf("%s is missing", name))
+			}
+			continue
+		}
+
+		if manifest != nil && manifestObject == nil {
+			problems = append(problems, fmt.Sprintf("%s is not listed in the manifest", name))
+		}
+
+		if signature != nil && signed == nil {
+			problems = append(problems, fmt.Sprintf("%s is not covered by the signature", name))
+		}
+
+		verifyOpts := lib.SwiftVerifyOpts{
+			StorageContainer: storageContainerName,
+			ObjectName:       name,
+			Meta:             role == lib.ManifestRoleMeta,
+			Manifest:         manifestObject,
+			Signed:           signed,
+			CryptOpts:        decryptOpts,
+			Legacy:           legacy,
+			Fingerprint:      fingerprint,
+		}
+
+		log.Infof("Verifying %s", name)
+		result, err := lib.SwiftVerifyObject(swiftClient, verifyOpts)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		verified++
+
+		content := "content checked"
+		if !result.Checked {
+			content = "content not checked, as it is encrypted"
+		}
+		fmt.Printf("%s: %d bytes, sha256 %s, %s\n", name, result.Size, result.SHA256, content)
+
+		if result.Checked {
+			checked++
+		}
+	}
+
+	// The fingerprint is only known if all objects were read in plain.
+	if manifest != nil && manifest.Fingerprint != "" && verified > 0 && checked == verified && len(problems) == 0 {
+		if sum := hex.EncodeToString(fingerprint.Sum(nil)); sum != manifest.Fingerprint {
+			problems = append(problems, fmt.Sprintf("Fingerprint of %s is %s instead of %s", objectName, sum, manifest.Fingerprint))
+		} else {
+			fmt.Printf("%s: fingerprint %s\n", objectName, sum)
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			log.Error(p)
+		}
+
+		return fmt.Errorf("%s failed verification with %d problem(s)", objectName, len(problems))
+	}
+
+	if err := lib.SwiftMarkVerified(swiftClient, storageContainerName, objectName, time.Now()); err != nil {
+		log.Warnf("Unable to mark %s as verified: %s", objectName, err)
+	}
+
+	log.Infof("%s is intact", objectName)
+	return nil
+}
@@ -1,6 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/jtopjian/limbo/lib"
+	"github.com/lxc/lxd/shared/termios"
+
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
@@ -9,9 +25,404 @@ var cryptFlags = []cli.Flag{
 		Name:  "encrypt",
 		Usage: "encrypt/decrypt the image",
 	},
+	cli.BoolFlag{
+		Name:  "obfuscate-names",
+		Usage: "store encrypted images under opaque object names",
+	},
+	cli.StringFlag{
+		Name:  "encrypt-mode",
+		Usage: "encryption format: limbo or openpgp",
+		Value: lib.CryptModeLimbo,
+	},
 	cli.StringFlag{
 		Name:   "pass",
 		Usage:  "passphrase for encryption/decryption",
 		EnvVar: "LIMBO_PASS,PASS",
 	},
+	cli.StringFlag{
+		Name:   "pass-file",
+		Usage:  "file containing the passphrase for encryption/decryption",
+		EnvVar: "LIMBO_PASS_FILE",
+	},
+	cli.StringFlag{
+		Name:   "pass-command",
+		Usage:  "command which prints the passphrase for encryption/decryption",
+		EnvVar: "LIMBO_PASS_COMMAND",
+	},
+	cli.StringFlag{
+		Name:   "key-file",
+		Usage:  "secret key file for encryption/decryption, as created by keygen",
+		EnvVar: "LIMBO_KEY_FILE",
+	},
+	cli.StringFlag{
+		Name:  "kdf-cost",
+		Usage: "cost of deriving a key from the passphrase: interactive, moderate, sensitive or N,r,p",
+		Value: "interactive",
+	},
+	cli.StringSliceFlag{
+		Name:  "recipient",
+		Usage: "public key file to encrypt the image to. Can be repeated.",
+	},
+	cli.StringFlag{
+		Name:   "identity",
+		Usage:  "private key file for decryption",
+		EnvVar: "LIMBO_IDENTITY",
+	},
+	cli.StringFlag{
+		Name:  "recover-from",
+		Usage: "comma-separated key share files to rebuild a split key or private key from",
+	},
+}
+
+// newCryptOpts reads the secrets specified by cryptFlags and kmsFlags. The
+// names of the flags can be prefixed, so a command can accept two sets of
+// secrets. If no passphrase was given, the user is prompted for it once it
+// is needed. With confirm set, the passphrase has to be entered twice. The
+// Barbican key manager uses the Keystone session of swiftClient.
+func newCryptOpts(ctx *cli.Context, log *logrus.Logger, prefix string, confirm bool, swiftClient *gophercloud.ServiceClient) (lib.CryptOpts, error) {
+	pass, err := readPass(ctx, prefix)
+	if err != nil {
+		return lib.CryptOpts{}, err
+	}
+
+	kdf, err := parseKDFCost(ctx.String(prefix + "kdf-cost"))
+	if err != nil {
+		return lib.CryptOpts{}, err
+	}
+
+	cryptOpts := lib.CryptOpts{
+		Pass:   pass,
+		KDF:    kdf,
+		Logger: log,
+		Mode:   ctx.String(prefix + "encrypt-mode"),
+	}
+
+	switch cryptOpts.Mode {
+	case "":
+		cryptOpts.Mode = lib.CryptModeLimbo
+	case lib.CryptModeLimbo:
+	case lib.CryptModeOpenPGP:
+		if ctx.String(prefix+"key-file") != "" {
+			return cryptOpts, fmt.Errorf("--%skey-file can not be used in OpenPGP mode", prefix)
+		}
+
+		if ctx.String(prefix+"kms") != "" {
+			return cryptOpts, fmt.Errorf("--%skms can not be used in OpenPGP mode", prefix)
+		}
+
+		if ctx.String(prefix+"recover-from") != "" {
+			return cryptOpts, fmt.Errorf("--%srecover-from can not be used in OpenPGP mode", prefix)
+		}
+	default:
+		return cryptOpts, fmt.Errorf("Unknown encryption mode: %s", cryptOpts.Mode)
+	}
+
+	if pass == "" {
+		var once sync.Once
+		var promptErr error
+		cryptOpts.PassFunc = func() (string, error) {
+			once.Do(func() {
+				pass, promptErr = promptPass(prefix, confirm)
+			})
+			return pass, promptErr
+		}
+	}
+
+	if ctx.String(prefix+"kms") != "" && (ctx.String(prefix+"key-file") != "" || len(ctx.StringSlice(prefix+"recipient")) > 0) {
+		return cryptOpts, fmt.Errorf("--%[1]skms can not be combined with --%[1]skey-file or --%[1]srecipient", prefix)
+	}
+
+	cryptOpts.KeyManager, err = newKeyManager(ctx, log, prefix, swiftClient)
+	if err != nil {
+		return cryptOpts, err
+	}
+
+	if v := ctx.String(prefix + "key-file"); v != "" {
+		data, err := ioutil.ReadFile(v)
+		if err != nil {
+			return cryptOpts, fmt.Errorf("Unable to read key file: %s", err)
+		}
+
+		keys, err := lib.ParseKeys(lib.PEMSecretKey, data)
+		if err != nil {
+			return cryptOpts, fmt.Errorf("Unable to parse %s: %s", v, err)
+		}
+
+		cryptOpts.Key = keys[0]
+	}
+
+	for _, v := range ctx.StringSlice(prefix + "recipient") {
+		data, err := ioutil.ReadFile(v)
+		if err != nil {
+			return cryptOpts, fmt.Errorf("Unable to read public key file: %s", err)
+		}
+
+		if cryptOpts.Mode == lib.CryptModeOpenPGP {
+			keyring, err := lib.ParsePGPKeyRing(data)
+			if err != nil {
+				return cryptOpts, fmt.Errorf("Unable to parse %s: %s", v, err)
+			}
+
+			cryptOpts.PGPRecipients = append(cryptOpts.PGPRecipients, keyring...)
+			continue
+		}
+
+		keys, err := lib.ParseKeys(lib.PEMPublicKey, data)
+		if err != nil {
+			return cryptOpts, fmt.Errorf("Unable to parse %s: %s", v, err)
+		}
+
+		cryptOpts.Recipients = append(cryptOpts.Recipients, keys...)
+	}
+
+	if v := ctx.String(prefix + "identity"); v != "" {
+		data, err := ioutil.ReadFile(v)
+		if err != nil {
+			return cryptOpts, fmt.Errorf("Unable to read private key file: %s", err)
+		}
+
+		if cryptOpts.Mode == lib.CryptModeOpenPGP {
+			keyring, err := lib.ParsePGPKeyRing(data)
+			if err != nil {
+				return cryptOpts, fmt.Errorf("Unable to parse %s: %s", v, err)
+			}
+
+			cryptOpts.PGPKeyring = keyring
+		} else {
+			keys, err := lib.ParseKeys(lib.PEMPrivateKey, data)
+			if err != nil {
+				return cryptOpts, fmt.Errorf("Unable to parse %s: %s", v, err)
+			}
+
+			cryptOpts.Identities = keys
+		}
+	}
+
+	if v := ctx.String(prefix + "recover-from"); v != "" {
+		if err := recoverKey(&cryptOpts, strings.Split(v, ",")); err != nil {
+			return cryptOpts, err
+		}
+	}
+
+	return cryptOpts, nil
+}
+
+// recoverKey rebuilds a key from the share files and adds it to cryptOpts,
+// depending on its type as the secret key or as a private key.
+func recoverKey(cryptOpts *lib.CryptOpts, paths []string) error {
+	var shares []lib.KeyShare
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Unable to read key share: %s", err)
+		}
+
+		share, err := lib.ParseKeyShare(data)
+		if err != nil {
+			return fmt.Errorf("Unable to parse %s: %s", path, err)
+		}
+
+		shares = append(shares, *share)
+	}
+
+	keyType, data, err := lib.CombineKeyShares(shares)
+	if err != nil {
+		return fmt.Errorf("Unable to recover key: %s", err)
+	}
+
+	if len(data) != 32 {
+		return fmt.Errorf("Unable to recover key: unexpected key size %d", len(data))
+	}
+
+	var key [32]byte
+	copy(key[:], data)
+
+	switch keyType {
+	case lib.PEMSecretKey:
+		if cryptOpts.Key != nil {
+			return fmt.Errorf("--recover-from rebuilt a secret key. It can not be combined with --key-file")
+		}
+		cryptOpts.Key = &key
+	case lib.PEMPrivateKey:
+		cryptOpts.Identities = append(cryptOpts.Identities, &key)
+	default:
+		return fmt.Errorf("Unable to recover key: unsupported key type %s", keyType)
+	}
+
+	return nil
+}
+
+// parseKDFCost parses the name of a KDF preset or explicit scrypt parameters
+// in the form N,r,p. An empty value selects the default parameters.
+func parseKDFCost(v string) (lib.KDFParams, error) {
+	if v == "" {
+		return lib.DefaultKDFParams, nil
+	}
+
+	if params, ok := lib.KDFPresets[v]; ok {
+		return params, nil
+	}
+
+	fields := strings.Split(v, ",")
+	if len(fields) != 3 {
+		return lib.KDFParams{}, fmt.Errorf("Invalid KDF cost: %s", v)
+	}
+
+	var values [3]uint64
+	for i, f := range fields {
+		n, err := strconv.ParseUint(strings.TrimSpace(f), 10, 32)
+		if err != nil || n == 0 {
+			return lib.KDFParams{}, fmt.Errorf("Invalid KDF cost: %s", v)
+		}
+		values[i] = n
+	}
+
+	// N has to be a power of two.
+	n := values[0]
+	if n < 2 || n&(n-1) != 0 {
+		return lib.KDFParams{}, fmt.Errorf("Invalid KDF cost: N must be a power of 2")
+	}
+
+	params := lib.KDFParams{
+		R: uint32(values[1]),
+		P: uint32(values[2]),
+	}
+
+	for n > 1 && params.LogN < 64 {
+		n >>= 1
+		params.LogN++
+	}
+
+	return params, params.Validate()
+}
+
+// readPass reads the passphrase from --pass, --pass-file or --pass-command.
+// An empty string is returned if none of them were given.
+func readPass(ctx *cli.Context, prefix string) (string, error) {
+	pass := ctx.String(prefix + "pass")
+	passFile := ctx.String(prefix + "pass-file")
+	passCommand := ctx.String(prefix + "pass-command")
+
+	given := 0
+	for _, v := range []string{pass, passFile, passCommand} {
+		if v != "" {
+			given++
+		}
+	}
+
+	if given > 1 {
+		return "", fmt.Errorf("only one of --%[1]spass, --%[1]spass-file and --%[1]spass-command can be used", prefix)
+	}
+
+	if passFile != "" {
+		data, err := ioutil.ReadFile(passFile)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read passphrase file: %s", err)
+		}
+
+		pass = strings.TrimRight(string(data), "\r\n")
+		if pass == "" {
+			return "", fmt.Errorf("Passphrase file %s is empty", passFile)
+		}
+	}
+
+	if passCommand != "" {
+		cmd := exec.Command("sh", "-c", passCommand)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("Unable to run passphrase command: %s", err)
+		}
+
+		// Only the first line is used, like "pass show" expects.
+		pass = strings.SplitN(string(out), "\n", 2)[0]
+		pass = strings.TrimRight(pass, "\r")
+		if pass == "" {
+			return "", fmt.Errorf("Passphrase command printed an empty passphrase")
+		}
+	}
+
+	return pass, nil
+}
+
+// promptPass asks for a passphrase on the terminal without echoing it. The
+// flag prefix is used to tell the user which passphrase is asked for.
+func promptPass(prefix string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !termios.IsTerminal(fd) {
+		return "", fmt.Errorf("A passphrase is required. Use --%[1]spass, --%[1]spass-file or --%[1]spass-command", prefix)
+	}
+
+	label := "Passphrase"
+	if prefix != "" {
+		label = strings.Title(strings.TrimSuffix(prefix, "-")) + " passphrase"
+	}
+
+	pass, err := readNoEcho(fd, label+": ")
+	if err != nil {
+		return "", err
+	}
+
+	if pass == "" {
+		return "", fmt.Errorf("Empty passphrases are not allowed")
+	}
+
+	if confirm {
+		again, err := readNoEcho(fd, "Confirm "+strings.ToLower(label)+": ")
+		if err != nil {
+			return "", err
+		}
+
+		if again != pass {
+			return "", fmt.Errorf("Passphrases do not match")
+		}
+	}
+
+	return pass, nil
+}
+
+// readNoEcho reads a line from the terminal with echo turned off. The
+// terminal is restored even if limbo is interrupted.
+func readNoEcho(fd int, prompt string) (string, error) {
+	state, err := termios.GetState(fd)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read terminal state: %s", err)
+	}
+
+	noEcho := *state
+	noEcho.Termios.Lflag &^= syscall.ECHO
+	if err := termios.Restore(fd, &noEcho); err != nil {
+		return "", fmt.Errorf("Unable to turn off terminal echo: %s", err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			termios.Restore(fd, state)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		signal.Stop(sigs)
+		close(done)
+		termios.Restore(fd, state)
+		fmt.Fprintln(os.Stderr)
+	}()
+
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Unable to read passphrase: %s", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
 }
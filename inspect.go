@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+)
+
+var inspectFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-container",
+		Usage: "Swift Container the image is stored in.",
+		Value: "limbo",
+	},
+	cli.StringFlag{
+		Name:  "object-name",
+		Usage: "Object name of the image to inspect.",
+	},
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format: text or json.",
+		Value: "text",
+	},
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var kmsFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "kms",
+		Usage:  "key manager which wraps the data key: vault or barbican",
+		EnvVar: "LIMBO_KMS",
+	},
+	cli.StringFlag{
+		Name:   "kms-key",
+		Usage:  "Vault transit key name or Barbican secret ID to wrap data keys with",
+		EnvVar: "LIMBO_KMS_KEY",
+	},
+	cli.StringFlag{
+		Name:   "vault-addr",
+		Usage:  "address of the Vault server",
+		EnvVar: "VAULT_ADDR",
+	},
+	cli.StringFlag{
+		Name:   "vault-token",
+		Usage:  "Vault token",
+		EnvVar: "VAULT_TOKEN",
+	},
+	cli.StringFlag{
+		Name:   "vault-namespace",
+		Usage:  "Vault namespace",
+		EnvVar: "VAULT_NAMESPACE",
+	},
+	cli.StringFlag{
+		Name:   "vault-cacert",
+		Usage:  "CA certificate of the Vault server",
+		EnvVar: "VAULT_CACERT",
+	},
+	cli.StringFlag{
+		Name:  "vault-transit-mount",
+		Usage: "path the Vault transit engine is mounted at",
+		Value: "transit",
+	},
+}
+
+// newKeyManager creates the key manager selected by --kms. The key manager
+// and key flags can be prefixed like the other crypt flags, while the Vault
+// connection and the Keystone session of swiftClient are shared. nil is
+// returned if no key manager was selected.
+func newKeyManager(ctx *cli.Context, log *logrus.Logger, prefix string, swiftClient *gophercloud.ServiceClient) (lib.KeyManager, error) {
+	key := ctx.String(prefix + "kms-key")
+
+	switch ctx.String(prefix + "kms") {
+	case "":
+		if key != "" {
+			return nil, fmt.Errorf("--%[1]skms-key requires --%[1]skms", prefix)
+		}
+		return nil, nil
+	case lib.KeyManagerVault:
+		vault, err := lib.NewVaultTransit(lib.VaultTransitOpts{
+			Address:   ctx.String("vault-addr"),
+			Token:     ctx.String("vault-token"),
+			Namespace: ctx.String("vault-namespace"),
+			Mount:     ctx.String("vault-transit-mount"),
+			CACert:    ctx.String("vault-cacert"),
+			Key:       key,
+			Retry:     newRetryOpts(ctx, log),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return vault, nil
+	case lib.KeyManagerBarbican:
+		barbican, err := lib.NewBarbican(swiftClient, ctx.String("os-region-name"), key)
+		if err != nil {
+			return nil, err
+		}
+		return barbican, nil
+	default:
+		return nil, fmt.Errorf("Unknown key manager: %s", ctx.String(prefix+"kms"))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/jtopjian/limbo/lib"
+	"github.com/urfave/cli"
+)
+
+var labelFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-container",
+		Usage: "Swift Container the image is stored in.",
+		Value: "limbo",
+	},
+	cli.StringFlag{
+		Name:  "object-name",
+		Usage: "Object name of the image to label.",
+	},
+	cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "key=value or key label to set. Can be repeated.",
+	},
+	cli.StringSliceFlag{
+		Name:  "remove-label",
+		Usage: "key of a label to remove. Can be repeated.",
+	},
+}
+
+// newSelector parses --selector. nil is returned if no selector was given.
+func newSelector(ctx *cli.Context) (lib.Selector, error) {
+	v := ctx.String("selector")
+	if v == "" {
+		return nil, nil
+	}
+
+	return lib.ParseSelector(v)
+}
+
+// selectImages returns the images whose labels match selector.
+func selectImages(images []lib.SwiftImageSummary, selector lib.Selector) []lib.SwiftImageSummary {
+	if selector == nil {
+		return images
+	}
+
+	var matched []lib.SwiftImageSummary
+	for _, image := range images {
+		if selector.Matches(image.Labels) {
+			matched = append(matched, image)
+		}
+	}
+
+	return matched
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Barbican is a KeyManager which keeps the key data keys are wrapped with
+// in OpenStack Barbican. Barbican stores secrets but does not encrypt with
+// them, so the key is fetched and data keys are wrapped locally with
+// secretbox. Access to the key is controlled by Barbican's ACLs.
+type Barbican struct {
+	client *gophercloud.ServiceClient
+	secret string
+	keys   map[string]*[32]byte
+}
+
+// NewBarbican returns a KeyManager for Barbican. It uses the Keystone
+// session of an authenticated client, such as the one GetSwiftClient
+// returns. secret is the ID or the URL of a 256-bit symmetric secret, and
+// can be empty if the key manager is only used to decrypt.
+func NewBarbican(client *gophercloud.ServiceClient, region, secret string) (*Barbican, error) {
+	if client.EndpointLocator == nil {
+		return nil, fmt.Errorf("Barbican requires Keystone authentication")
+	}
+
+	eo := gophercloud.EndpointOpts{
+		Region: region,
+	}
+	eo.ApplyDefaults("key-manager")
+
+	endpoint, err := client.EndpointLocator(eo)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find the Barbican endpoint: %s", err)
+	}
+
+	endpoint = strings.TrimSuffix(endpoint, "/") + "/"
+	base := endpoint
+	if !strings.HasSuffix(base, "/v1/") {
+		base += "v1/"
+	}
+
+	barbicanClient := &gophercloud.ServiceClient{
+		ProviderClient: client.ProviderClient,
+		Endpoint:       endpoint,
+		ResourceBase:   base,
+		Type:           "key-manager",
+	}
+
+	// Only the ID is stored, so data can still be decrypted if the
+	// endpoint changes.
+	if i := strings.LastIndex(secret, "/"); i >= 0 {
+		secret = secret[i+1:]
+	}
+
+	return &Barbican{
+		client: barbicanClient,
+		secret: secret,
+		keys:   map[string]*[32]byte{},
+	}, nil
+}
+
+func (b *Barbican) Name() string {
+	return KeyManagerBarbican
+}
+
+func (b *Barbican) KeyID() string {
+	return b.secret
+}
+
+// WrapKey seals the data key with the secret:
+//
+//	nonce(24) | box
+func (b *Barbican) WrapKey(key *[32]byte) ([]byte, error) {
+	kek, err := b.key(b.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, fmt.Errorf("Unable to generate nonce: %s", err)
+	}
+
+	return secretbox.Seal(nonce[:], key[:], &nonce, kek), nil
+}
+
+// UnwrapKey opens a data key sealed by WrapKey with the secret keyID.
+func (b *Barbican) UnwrapKey(keyID string, wrapped []byte) (*[32]byte, error) {
+	kek, err := b.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < 24 {
+		return nil, fmt.Errorf("Wrapped key is malformed")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], wrapped[:24])
+
+	key, ok := secretbox.Open(nil, wrapped[24:], &nonce, kek)
+	if !ok || len(key) != 32 {
+		return nil, fmt.Errorf("Wrapped key does not match the secret")
+	}
+
+	var secretKey [32]byte
+	copy(secretKey[:], key)
+
+	return &secretKey, nil
+}
+
+// key fetches the payload of a secret. Secrets are cached, so each is only
+// fetched once.
+func (b *Barbican) key(id string) (*[32]byte, error) {
+	if kek, ok := b.keys[id]; ok {
+		return kek, nil
+	}
+
+	resp, err := b.client.Get(b.client.ServiceURL("secrets", id, "payload"), nil, &gophercloud.RequestOpts{
+		MoreHeaders: map[string]string{
+			"Accept": "application/octet-stream",
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get Barbican secret %s: %s", id, err)
+	}
+	defer resp.Body.Close()
+
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read Barbican secret %s: %s", id, err)
+	}
+
+	if len(payload) != 32 {
+		return nil, fmt.Errorf("Barbican secret %s is not a 256-bit key", id)
+	}
+
+	var kek [32]byte
+	copy(kek[:], payload)
+	b.keys[id] = &kek
+
+	return &kek, nil
+}
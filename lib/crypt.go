@@ -1,13 +1,18 @@
 package lib
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/box"
 	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/scrypt"
 )
 
@@ -15,79 +20,810 @@ import (
 // https://godoc.org/golang.org/x/crypto/nacl/secretbox
 // https://github.com/danderson/gobox
 
-func Encrypt(file, pass string) error {
-	f, err := ioutil.ReadFile(file)
-	if err != nil {
-		return fmt.Errorf("Unable to read file %s: %s", file, err)
+// Encrypted data starts with a header which identifies it:
+//
+//	magic(5) | version(1) | algorithm(1) | kdf(1) | len(4) | kdf params | nonce prefix(15)
+//
+// The KDF describes how the key is obtained. It is either derived from a
+// passphrase with scrypt, a random key which is wrapped for a list of
+// recipients, a key read from a key file which is used as is, or a random
+// key which is wrapped by an external key manager.
+//
+// The rest of the data is split into chunks of cryptChunkSize bytes which
+// are each sealed as a secretbox message. The nonce of a chunk consists of
+// the nonce prefix, a counter and a flag marking the final chunk, so chunks
+// which were reordered, dropped or cut off fail to decrypt.
+//
+// Two older formats can still be decrypted. Version 1 of the format had
+// no algorithm or KDF fields:
+//
+//	magic(5) | 1 | salt(24) | nonce prefix(15) | chunks
+//
+// And data encrypted before chunking was introduced has no header at all:
+//
+//	salt(24) | nonce(24) | box
+var cryptMagic = []byte("LIMBO")
+
+// Format versions.
+const (
+	cryptVersionChunked = 1
+	cryptVersionHeader  = 2
+)
+
+// Algorithm IDs.
+const (
+	cryptAlgorithmSecretbox = 1
+)
+
+// KDF IDs.
+const (
+	cryptKDFScrypt     = 1
+	cryptKDFRecipients = 2
+	cryptKDFKey        = 3
+	cryptKDFEnvelope   = 4
+)
+
+const (
+	cryptChunkSize     = 64 * 1024
+	cryptPrefixSize    = 15
+	cryptSaltSize      = 24
+	cryptMaxParamsSize = 1024 * 1024
+)
+
+// CryptOpts holds the secrets used to encrypt and decrypt data.
+type CryptOpts struct {
+	// Pass is a passphrase the key is derived from.
+	Pass string
+
+	// PassFunc is called to obtain the passphrase if Pass is empty and
+	// a passphrase is needed.
+	PassFunc func() (string, error)
+
+	// Key is a full-entropy secret key. It is used directly instead of
+	// deriving a key from Pass.
+	Key *[32]byte
+
+	// Recipients are the public keys data is encrypted to. If set, Pass and
+	// Key are not used to encrypt.
+	Recipients []*[32]byte
+
+	// Identities are the private keys used to decrypt data which was
+	// encrypted to recipients.
+	Identities []*[32]byte
+
+	// KeyManager wraps a random data key for each encryption. If set,
+	// Pass and Key are not used to encrypt. It is also needed to decrypt
+	// data whose key it wrapped.
+	KeyManager KeyManager
+
+	// KDF are the scrypt parameters used to derive a key from Pass. If
+	// unset, DefaultKDFParams are used. Decryption uses the parameters
+	// stored with the data.
+	KDF KDFParams
+
+	// Logger receives warnings about weakly encrypted data.
+	Logger *logrus.Logger
+
+	// Mode is the encryption format, CryptModeLimbo or CryptModeOpenPGP.
+	// An empty mode is the same as CryptModeLimbo.
+	Mode string
+
+	// PGPRecipients are the OpenPGP public keys data is encrypted to in
+	// OpenPGP mode. If none are given, the passphrase is used.
+	PGPRecipients openpgp.EntityList
+
+	// PGPKeyring holds the OpenPGP private keys used to decrypt data in
+	// OpenPGP mode.
+	PGPKeyring openpgp.EntityList
+}
+
+// passphrase returns the passphrase, asking PassFunc for it if needed.
+// Empty passphrases are refused.
+func (o CryptOpts) passphrase() (string, error) {
+	pass := o.Pass
+	if pass == "" && o.PassFunc != nil {
+		var err error
+		pass, err = o.PassFunc()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if pass == "" {
+		return "", fmt.Errorf("A passphrase is required")
+	}
+
+	return pass, nil
+}
+
+// NeedsPassphrase determines if Encrypt has to ask PassFunc for a
+// passphrase, because no passphrase, key, recipient or key manager was
+// given.
+func (o CryptOpts) NeedsPassphrase() bool {
+	if o.Pass != "" {
+		return false
+	}
+
+	if o.Mode == CryptModeOpenPGP {
+		return len(o.PGPRecipients) == 0
+	}
+
+	return o.Key == nil && !o.wrapsKey()
+}
+
+// Scheme describes how Encrypt encrypts data with these options, such as
+// limbo-scrypt or openpgp-recipients.
+func (o CryptOpts) Scheme() string {
+	if o.Mode == CryptModeOpenPGP {
+		if len(o.PGPRecipients) > 0 {
+			return "openpgp-recipients"
+		}
+		return "openpgp-passphrase"
+	}
+
+	switch {
+	case len(o.Recipients) > 0:
+		return "limbo-recipients"
+	case o.KeyManager != nil:
+		return "limbo-" + o.KeyManager.Name()
+	case o.Key != nil:
+		return "limbo-key"
+	}
+
+	return "limbo-scrypt"
+}
+
+// cryptHeader describes how data was encrypted.
+type cryptHeader struct {
+	Version     byte
+	Algorithm   byte
+	KDF         byte
+	KDFParams   []byte
+	NoncePrefix []byte
+}
+
+func (h cryptHeader) marshal() []byte {
+	var b bytes.Buffer
+	b.Write(cryptMagic)
+	b.WriteByte(h.Version)
+	b.WriteByte(h.Algorithm)
+	b.WriteByte(h.KDF)
+	binary.Write(&b, binary.BigEndian, uint32(len(h.KDFParams)))
+	b.Write(h.KDFParams)
+	b.Write(h.NoncePrefix)
+
+	return b.Bytes()
+}
+
+// readCryptHeader reads the header of encrypted data. If the data has no
+// header, nil is returned and nothing is consumed from br.
+func readCryptHeader(br *bufio.Reader) (*cryptHeader, error) {
+	if !HasCryptHeader(br) {
+		return nil, nil
+	}
+
+	magic := make([]byte, len(cryptMagic)+1)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+
+	h := &cryptHeader{
+		Version: magic[len(cryptMagic)],
+	}
+
+	switch h.Version {
+	case cryptVersionChunked:
+		h.Algorithm = cryptAlgorithmSecretbox
+		h.KDF = cryptKDFScrypt
+		h.KDFParams = make([]byte, cryptSaltSize)
+		if _, err := io.ReadFull(br, h.KDFParams); err != nil {
+			return nil, fmt.Errorf("Encrypted data is malformed")
+		}
+
+		// Version 1 used fixed scrypt parameters.
+		h.KDFParams = scryptParams{
+			Salt:      h.KDFParams,
+			KDFParams: KDFParams{LogN: 14, R: 8, P: 1},
+		}.marshal()
+	case cryptVersionHeader:
+		fields := make([]byte, 2)
+		if _, err := io.ReadFull(br, fields); err != nil {
+			return nil, fmt.Errorf("Encrypted data is malformed")
+		}
+		h.Algorithm = fields[0]
+		h.KDF = fields[1]
+
+		var length uint32
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil || length > cryptMaxParamsSize {
+			return nil, fmt.Errorf("Encrypted data is malformed")
+		}
+
+		h.KDFParams = make([]byte, length)
+		if _, err := io.ReadFull(br, h.KDFParams); err != nil {
+			return nil, fmt.Errorf("Encrypted data is malformed")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported encryption format version: %d", h.Version)
 	}
 
-	out := make([]byte, 24+24+len(f)+secretbox.Overhead)
-	if _, err = io.ReadFull(rand.Reader, out[:24]); err != nil {
-		return fmt.Errorf("Unable to generate random salt")
+	h.NoncePrefix = make([]byte, cryptPrefixSize)
+	if _, err := io.ReadFull(br, h.NoncePrefix); err != nil {
+		return nil, fmt.Errorf("Encrypted data is malformed")
 	}
 
-	s, err := scrypt.Key([]byte(pass), out[:24], 16384, 8, 1, 32)
+	return h, nil
+}
+
+// HasCryptHeader determines if the data buffered in br starts with the
+// header of data encrypted by limbo. Nothing is consumed from br.
+func HasCryptHeader(br *bufio.Reader) bool {
+	magic, err := br.Peek(len(cryptMagic))
+	return err == nil && bytes.Equal(magic, cryptMagic)
+}
+
+// KDFParams are the cost parameters of scrypt. N is 2^LogN.
+type KDFParams struct {
+	LogN byte
+	R    uint32
+	P    uint32
+}
+
+// KDFPresets are named sets of scrypt parameters. Higher costs make guessing
+// passphrases harder, but also take more time and memory to decrypt.
+var KDFPresets = map[string]KDFParams{
+	"interactive": {LogN: 15, R: 8, P: 1},
+	"moderate":    {LogN: 17, R: 8, P: 1},
+	"sensitive":   {LogN: 20, R: 8, P: 1},
+}
+
+// DefaultKDFParams are used if no parameters were given.
+var DefaultKDFParams = KDFPresets["interactive"]
+
+// memory returns the amount of memory scrypt needs with these parameters.
+func (p KDFParams) memory() uint64 {
+	return 128 * uint64(p.R) << p.LogN
+}
+
+// Weak determines if the parameters are cheaper than DefaultKDFParams.
+func (p KDFParams) Weak() bool {
+	return p.memory() < DefaultKDFParams.memory()
+}
+
+// KDFMaxCost bounds 128*r*N*p, the memory scrypt needs times the number of
+// times it is needed. It is the cost of the sensitive preset. Parameters
+// are read from untrusted headers, so anything above it is refused before
+// a key is derived.
+const KDFMaxCost = 1 << 30
+
+// Validate checks that the parameters are within the limits limbo accepts,
+// which bound the memory and time needed to derive a key.
+func (p KDFParams) Validate() error {
+	if p.LogN == 0 || p.LogN > 30 || p.R == 0 || p.R > 64 || p.P == 0 || p.P > 64 {
+		return fmt.Errorf("Invalid scrypt parameters: %s", p)
+	}
+
+	if p.memory()*uint64(p.P) > KDFMaxCost {
+		return fmt.Errorf("The scrypt parameters %s exceed the limit of %d MiB", p, KDFMaxCost>>20)
+	}
+
+	return nil
+}
+
+func (p KDFParams) String() string {
+	return fmt.Sprintf("N=%d, r=%d, p=%d", uint64(1)<<p.LogN, p.R, p.P)
+}
+
+// scryptParams are the KDF parameters of the scrypt KDF.
+//
+//	salt(24) | log2(N)(1) | r(4) | p(4)
+type scryptParams struct {
+	Salt []byte
+	KDFParams
+}
+
+func (p scryptParams) marshal() []byte {
+	b := make([]byte, cryptSaltSize+1+4+4)
+	copy(b, p.Salt)
+	b[cryptSaltSize] = p.LogN
+	binary.BigEndian.PutUint32(b[cryptSaltSize+1:], p.R)
+	binary.BigEndian.PutUint32(b[cryptSaltSize+5:], p.P)
+
+	return b
+}
+
+func parseScryptParams(b []byte) (*scryptParams, error) {
+	if len(b) != cryptSaltSize+1+4+4 {
+		return nil, fmt.Errorf("Invalid scrypt parameters")
+	}
+
+	p := &scryptParams{
+		Salt: b[:cryptSaltSize],
+		KDFParams: KDFParams{
+			LogN: b[cryptSaltSize],
+			R:    binary.BigEndian.Uint32(b[cryptSaltSize+1:]),
+			P:    binary.BigEndian.Uint32(b[cryptSaltSize+5:]),
+		},
+	}
+
+	return p, nil
+}
+
+// deriveKey derives a secretbox key from a passphrase.
+func (p scryptParams) deriveKey(pass string) (*[32]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	s, err := scrypt.Key([]byte(pass), p.Salt, 1<<p.LogN, int(p.R), int(p.P), 32)
 	if err != nil {
-		return fmt.Errorf("Unable to scrypt pass: %s", err)
+		return nil, fmt.Errorf("Unable to derive encryption key: %s", err)
 	}
+
 	var secretKey [32]byte
 	copy(secretKey[:], s)
 
-	var nonce [24]byte
-	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+	return &secretKey, nil
+}
+
+// Encrypt encrypts src and writes the result to dst. If recipients or a key
+// manager are given, the data is encrypted with a random key which is
+// wrapped for each recipient or by the key manager. Otherwise the secret
+// key is used, or a key is derived from the passphrase. Only one chunk is
+// held in memory at a time. In OpenPGP mode, an OpenPGP message is written
+// instead.
+func Encrypt(dst io.Writer, src io.Reader, opts CryptOpts) error {
+	if opts.Mode == CryptModeOpenPGP {
+		return pgpEncrypt(dst, src, opts)
+	}
+
+	h := cryptHeader{
+		Version:     cryptVersionHeader,
+		Algorithm:   cryptAlgorithmSecretbox,
+		NoncePrefix: make([]byte, cryptPrefixSize),
+	}
+
+	if _, err := io.ReadFull(rand.Reader, h.NoncePrefix); err != nil {
 		return fmt.Errorf("Unable to generate nonce: %s", err)
 	}
-	copy(out[24:48], nonce[:])
 
-	secretbox.Seal(out[:48], f, &nonce, &secretKey)
+	var secretKey *[32]byte
+	if opts.wrapsKey() {
+		secretKey = new([32]byte)
+		if _, err := io.ReadFull(rand.Reader, secretKey[:]); err != nil {
+			return fmt.Errorf("Unable to generate key: %s", err)
+		}
+
+		var err error
+		h.KDF, h.KDFParams, err = wrapDataKey(secretKey, opts)
+		if err != nil {
+			return err
+		}
+	} else if opts.Key != nil {
+		secretKey = opts.Key
+		h.KDF = cryptKDFKey
+	} else {
+		params := scryptParams{
+			Salt:      make([]byte, cryptSaltSize),
+			KDFParams: opts.KDF,
+		}
+
+		if params.KDFParams == (KDFParams{}) {
+			params.KDFParams = DefaultKDFParams
+		}
+
+		if _, err := io.ReadFull(rand.Reader, params.Salt); err != nil {
+			return fmt.Errorf("Unable to generate random salt")
+		}
 
-	newFile := file + ".nacl"
-	if err := ioutil.WriteFile(newFile, out, 0644); err != nil {
-		return fmt.Errorf("Unable to write encrypted file: %s", err)
+		pass, err := opts.passphrase()
+		if err != nil {
+			return err
+		}
+
+		secretKey, err = params.deriveKey(pass)
+		if err != nil {
+			return err
+		}
+
+		h.KDF = cryptKDFScrypt
+		h.KDFParams = params.marshal()
 	}
 
-	if err := os.Rename(newFile, file); err != nil {
-		return fmt.Errorf("Unable to rename encrypted file: %s", err)
+	if _, err := dst.Write(h.marshal()); err != nil {
+		return fmt.Errorf("Unable to write encrypted data: %s", err)
 	}
 
-	return nil
+	return encryptChunks(dst, src, secretKey, h.NoncePrefix)
+}
+
+// Decrypt decrypts src and writes the result to dst. How the data is
+// decrypted is determined by its header. Data in the chunked format is
+// decrypted one chunk at a time. Data without a header has to be read into
+// memory completely. In OpenPGP mode, src must be an OpenPGP message.
+func Decrypt(dst io.Writer, src io.Reader, opts CryptOpts) error {
+	if opts.Mode == CryptModeOpenPGP {
+		return pgpDecrypt(dst, src, opts)
+	}
+
+	br := bufio.NewReaderSize(src, cryptChunkSize+secretbox.Overhead)
+
+	h, err := readCryptHeader(br)
+	if err != nil {
+		return err
+	}
+
+	if h == nil {
+		if opts.Logger != nil {
+			opts.Logger.Warn("The data uses an old encryption format with weak scrypt parameters. Consider rekeying the data.")
+		}
+
+		pass, err := opts.passphrase()
+		if err != nil {
+			return err
+		}
+
+		return decryptLegacy(dst, br, pass)
+	}
+
+	var secretKey *[32]byte
+	switch h.KDF {
+	case cryptKDFScrypt:
+		params, err := parseScryptParams(h.KDFParams)
+		if err != nil {
+			return err
+		}
+
+		if params.Weak() && opts.Logger != nil {
+			opts.Logger.Warnf("The key was derived with weak scrypt parameters (%s). Consider rekeying the data.", params.KDFParams)
+		}
+
+		pass, err := opts.passphrase()
+		if err != nil {
+			return err
+		}
+
+		secretKey, err = params.deriveKey(pass)
+		if err != nil {
+			return err
+		}
+	case cryptKDFRecipients, cryptKDFEnvelope:
+		secretKey, err = unwrapDataKey(h, opts)
+		if err != nil {
+			return err
+		}
+	case cryptKDFKey:
+		if opts.Key == nil {
+			return fmt.Errorf("Data is encrypted with a key file. The key is required to decrypt it")
+		}
+		secretKey = opts.Key
+	default:
+		return fmt.Errorf("Unsupported key derivation function: %d", h.KDF)
+	}
+
+	switch h.Algorithm {
+	case cryptAlgorithmSecretbox:
+		return decryptChunks(dst, br, secretKey, h.NoncePrefix)
+	default:
+		return fmt.Errorf("Unsupported encryption algorithm: %d", h.Algorithm)
+	}
 }
 
-func Decrypt(file, pass string) error {
-	f, err := ioutil.ReadFile(file)
+// Rekey re-encrypts src, which was encrypted with oldOpts, with newOpts and
+// writes the result to dst. If the data key is wrapped for recipients or by
+// a key manager and newOpts has recipients or a key manager, only the data
+// key is wrapped again and the encrypted chunks are copied as they are.
+// Otherwise the data is decrypted and encrypted again one chunk at a time.
+// Data without a header is assumed to be in the legacy format.
+func Rekey(dst io.Writer, src io.Reader, oldOpts, newOpts CryptOpts) error {
+	if oldOpts.Mode == CryptModeOpenPGP || newOpts.Mode == CryptModeOpenPGP {
+		return reencrypt(dst, src, oldOpts, newOpts)
+	}
+
+	br := bufio.NewReaderSize(src, cryptChunkSize+secretbox.Overhead)
+
+	h, err := readCryptHeader(br)
 	if err != nil {
-		return fmt.Errorf("Unable to read file %s: %s", file, err)
+		return err
 	}
 
-	if len(f) < 48 {
-		return fmt.Errorf("Encrypted file %s is malformed", file)
+	if h != nil && (h.KDF == cryptKDFRecipients || h.KDF == cryptKDFEnvelope) && newOpts.wrapsKey() {
+		if err := rewrapHeader(h, oldOpts, newOpts); err != nil {
+			return err
+		}
+
+		if _, err := dst.Write(h.marshal()); err != nil {
+			return fmt.Errorf("Unable to write encrypted data: %s", err)
+		}
+
+		if _, err := io.Copy(dst, br); err != nil {
+			return fmt.Errorf("Unable to copy encrypted data: %s", err)
+		}
+
+		return nil
+	}
+
+	// The header has already been consumed, so it is put in front of the
+	// data again. Version 1 headers are written in the current format,
+	// which describes the same key and nonces.
+	var r io.Reader = br
+	if h != nil {
+		h.Version = cryptVersionHeader
+		r = io.MultiReader(bytes.NewReader(h.marshal()), br)
+	}
+
+	return reencrypt(dst, r, oldOpts, newOpts)
+}
+
+// RekeyHeader reads the header of data encrypted with oldOpts from br and
+// returns it with the data key wrapped again for newOpts, together with the
+// length of the old header. The encrypted chunks which follow the header
+// stay the same. If the data key is not wrapped for recipients or by a key
+// manager, or newOpts has neither, nil is returned and nothing is consumed
+// from br. Such data has to be re-encrypted with Rekey.
+func RekeyHeader(br *bufio.Reader, oldOpts, newOpts CryptOpts) ([]byte, int, error) {
+	if oldOpts.Mode == CryptModeOpenPGP || newOpts.Mode == CryptModeOpenPGP || !newOpts.wrapsKey() {
+		return nil, 0, nil
+	}
+
+	// The magic is followed by the version, the algorithm and the KDF.
+	fields, err := br.Peek(len(cryptMagic) + 3)
+	if err != nil || !bytes.Equal(fields[:len(cryptMagic)], cryptMagic) ||
+		fields[len(cryptMagic)] != cryptVersionHeader {
+		return nil, 0, nil
 	}
 
-	s, err := scrypt.Key([]byte(pass), f[:24], 16384, 8, 1, 32)
+	if kdf := fields[len(cryptMagic)+2]; kdf != cryptKDFRecipients && kdf != cryptKDFEnvelope {
+		return nil, 0, nil
+	}
+
+	h, err := readCryptHeader(br)
 	if err != nil {
-		return fmt.Errorf("Unable to derive encryption key: %s", err)
+		return nil, 0, err
 	}
+	oldSize := len(h.marshal())
 
-	var secretKey [32]byte
-	copy(secretKey[:], s)
+	if err := rewrapHeader(h, oldOpts, newOpts); err != nil {
+		return nil, 0, err
+	}
+
+	return h.marshal(), oldSize, nil
+}
+
+// rewrapHeader replaces the data key wrapped in h for oldOpts with the same
+// key wrapped for newOpts.
+func rewrapHeader(h *cryptHeader, oldOpts, newOpts CryptOpts) error {
+	secretKey, err := unwrapDataKey(h, oldOpts)
+	if err != nil {
+		return err
+	}
+
+	h.KDF, h.KDFParams, err = wrapDataKey(secretKey, newOpts)
+	return err
+}
+
+// reencrypt decrypts src with oldOpts and encrypts it with newOpts.
+func reencrypt(dst io.Writer, src io.Reader, oldOpts, newOpts CryptOpts) error {
+	plain := NewStreamReader(func(w io.Writer) error {
+		return Decrypt(w, src, oldOpts)
+	})
+	defer plain.Close()
+
+	return Encrypt(dst, plain, newOpts)
+}
+
+// wrapsKey determines if Encrypt uses a random data key which is wrapped
+// for recipients or by a key manager.
+func (o CryptOpts) wrapsKey() bool {
+	return len(o.Recipients) > 0 || o.KeyManager != nil
+}
+
+// wrapDataKey wraps a random data key for the recipients or, if there are
+// none, by the key manager. It returns the KDF and its parameters.
+func wrapDataKey(secretKey *[32]byte, opts CryptOpts) (byte, []byte, error) {
+	if len(opts.Recipients) > 0 {
+		params, err := wrapKey(secretKey, opts.Recipients)
+		return cryptKDFRecipients, params, err
+	}
+
+	params, err := wrapEnvelopeKey(secretKey, opts.KeyManager)
+	return cryptKDFEnvelope, params, err
+}
+
+// unwrapDataKey recovers a data key wrapped by wrapDataKey.
+func unwrapDataKey(h *cryptHeader, opts CryptOpts) (*[32]byte, error) {
+	if h.KDF == cryptKDFEnvelope {
+		return unwrapEnvelopeKey(h.KDFParams, opts.KeyManager)
+	}
+
+	return unwrapKey(h.KDFParams, opts.Identities)
+}
+
+// wrapKey encrypts a data key for each recipient with nacl/box, using a
+// single ephemeral key pair:
+//
+//	ephemeral public key(32) | count(2) | count * (nonce(24) | wrapped key(48))
+func wrapKey(secretKey *[32]byte, recipients []*[32]byte) ([]byte, error) {
+	if len(recipients) > 0xffff {
+		return nil, fmt.Errorf("Too many recipients")
+	}
+
+	ephemeralPublic, ephemeralPrivate, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate ephemeral key: %s", err)
+	}
+
+	var b bytes.Buffer
+	b.Write(ephemeralPublic[:])
+	binary.Write(&b, binary.BigEndian, uint16(len(recipients)))
+
+	for _, recipient := range recipients {
+		var nonce [24]byte
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return nil, fmt.Errorf("Unable to generate nonce: %s", err)
+		}
+
+		b.Write(nonce[:])
+		b.Write(box.Seal(nil, secretKey[:], &nonce, recipient, ephemeralPrivate))
+	}
+
+	return b.Bytes(), nil
+}
+
+// unwrapKey recovers a data key wrapped by wrapKey with any of the given
+// private keys.
+func unwrapKey(params []byte, identities []*[32]byte) (*[32]byte, error) {
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("Data is encrypted to public keys. A private key is required to decrypt it")
+	}
+
+	const entrySize = 24 + 32 + box.Overhead
+	if len(params) < 34 {
+		return nil, fmt.Errorf("Encrypted data is malformed")
+	}
+
+	var ephemeralPublic [32]byte
+	copy(ephemeralPublic[:], params[:32])
+	count := int(binary.BigEndian.Uint16(params[32:34]))
+	entries := params[34:]
+	if len(entries) != count*entrySize {
+		return nil, fmt.Errorf("Encrypted data is malformed")
+	}
+
+	for i := 0; i < count; i++ {
+		entry := entries[i*entrySize : (i+1)*entrySize]
+		var nonce [24]byte
+		copy(nonce[:], entry[:24])
+
+		for _, identity := range identities {
+			key, ok := box.Open(nil, entry[24:], &nonce, &ephemeralPublic, identity)
+			if ok {
+				var secretKey [32]byte
+				copy(secretKey[:], key)
+				return &secretKey, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("None of the private keys can decrypt the data")
+}
+
+// encryptChunks seals src in chunks of cryptChunkSize bytes.
+func encryptChunks(dst io.Writer, src io.Reader, secretKey *[32]byte, prefix []byte) error {
+	br := bufio.NewReaderSize(src, cryptChunkSize)
+	plain := make([]byte, cryptChunkSize)
+	out := make([]byte, 0, cryptChunkSize+secretbox.Overhead)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(br, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("Unable to read data: %s", err)
+		}
+
+		// The chunk is final if no data follows it.
+		final := err != nil
+		if !final {
+			if _, err := br.Peek(1); err != nil {
+				if err != io.EOF {
+					return fmt.Errorf("Unable to read data: %s", err)
+				}
+				final = true
+			}
+		}
+
+		nonce := chunkNonce(prefix, counter, final)
+		out = secretbox.Seal(out[:0], plain[:n], &nonce, secretKey)
+		if _, err := dst.Write(out); err != nil {
+			return fmt.Errorf("Unable to write encrypted data: %s", err)
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+// decryptChunks opens the chunks sealed by encryptChunks.
+func decryptChunks(dst io.Writer, br *bufio.Reader, secretKey *[32]byte, prefix []byte) error {
+	box := make([]byte, cryptChunkSize+secretbox.Overhead)
+	plain := make([]byte, 0, cryptChunkSize)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(br, box)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("Unable to read data: %s", err)
+		}
+
+		final := err != nil
+		if !final {
+			if _, err := br.Peek(1); err != nil {
+				if err != io.EOF {
+					return fmt.Errorf("Unable to read data: %s", err)
+				}
+				final = true
+			}
+		}
+
+		nonce := chunkNonce(prefix, counter, final)
+		var ok bool
+		plain, ok = secretbox.Open(plain[:0], box[:n], &nonce, secretKey)
+		if !ok {
+			return fmt.Errorf("Unable to decrypt data: wrong passphrase or key, or data is corrupted or truncated")
+		}
+
+		if _, err := dst.Write(plain); err != nil {
+			return fmt.Errorf("Unable to write decrypted data: %s", err)
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+// decryptLegacy decrypts data which was sealed as a single secretbox
+// message.
+func decryptLegacy(dst io.Writer, src io.Reader, pass string) error {
+	f, err := ioutil.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("Unable to read data: %s", err)
+	}
+
+	if len(f) < 48 {
+		return fmt.Errorf("Encrypted data is malformed")
+	}
+
+	params := scryptParams{
+		Salt:      f[:cryptSaltSize],
+		KDFParams: KDFParams{LogN: 14, R: 8, P: 1},
+	}
+
+	secretKey, err := params.deriveKey(pass)
+	if err != nil {
+		return err
+	}
 
 	var nonce [24]byte
 	copy(nonce[:], f[24:48])
 
-	newFile := file + ".plain"
-	plain, ok := secretbox.Open(nil, f[48:], &nonce, &secretKey)
+	plain, ok := secretbox.Open(nil, f[48:], &nonce, secretKey)
 	if !ok {
-		return fmt.Errorf("Unable to decrypt file %s", file)
+		return fmt.Errorf("Unable to decrypt data")
 	}
 
-	err = ioutil.WriteFile(newFile, plain, 0640)
-	if err != nil {
-		return fmt.Errorf("Unable to save decrypted file: %s", err)
+	if _, err := dst.Write(plain); err != nil {
+		return fmt.Errorf("Unable to write decrypted data: %s", err)
 	}
 
-	if err := os.Rename(newFile, file); err != nil {
-		return fmt.Errorf("Unable to rename decrypted file: %s", err)
+	return nil
+}
+
+// chunkNonce builds the nonce of a chunk from the nonce prefix, the chunk
+// counter and the final chunk flag.
+func chunkNonce(prefix []byte, counter uint64, final bool) [24]byte {
+	var nonce [24]byte
+	copy(nonce[:cryptPrefixSize], prefix)
+	binary.BigEndian.PutUint64(nonce[cryptPrefixSize:cryptPrefixSize+8], counter)
+	if final {
+		nonce[23] = 1
 	}
 
-	return nil
+	return nonce
 }
@@ -0,0 +1,396 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// cryptKeyHeaderSize is the size of the header of data encrypted with a key
+// file, which has no KDF parameters.
+const cryptKeyHeaderSize = 5 + 1 + 1 + 1 + 4 + cryptPrefixSize
+
+// cryptBoxSize is the size of a full encrypted chunk.
+const cryptBoxSize = cryptChunkSize + secretbox.Overhead
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func encryptBytes(t *testing.T, plain []byte, opts CryptOpts) []byte {
+	var b bytes.Buffer
+	if err := Encrypt(&b, bytes.NewReader(plain), opts); err != nil {
+		t.Fatalf("Encrypt failed: %s", err)
+	}
+
+	return b.Bytes()
+}
+
+func decryptBytes(data []byte, opts CryptOpts) ([]byte, error) {
+	var b bytes.Buffer
+	err := Decrypt(&b, bytes.NewReader(data), opts)
+	return b.Bytes(), err
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, privateKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cheapKDF := KDFParams{LogN: 10, R: 8, P: 1}
+
+	sizes := []int{
+		0,
+		1,
+		cryptChunkSize - 1,
+		cryptChunkSize,
+		cryptChunkSize + 1,
+		2 * cryptChunkSize,
+		2*cryptChunkSize + 1,
+	}
+
+	schemes := []struct {
+		name    string
+		encrypt CryptOpts
+		decrypt CryptOpts
+	}{
+		{"key", CryptOpts{Key: key}, CryptOpts{Key: key}},
+		{"passphrase", CryptOpts{Pass: "secret", KDF: cheapKDF}, CryptOpts{Pass: "secret"}},
+		{"recipients", CryptOpts{Recipients: []*[32]byte{publicKey}}, CryptOpts{Identities: []*[32]byte{privateKey}}},
+	}
+
+	for _, scheme := range schemes {
+		for _, size := range sizes {
+			plain := randomBytes(t, size)
+			data := encryptBytes(t, plain, scheme.encrypt)
+
+			// Every chunk, including the final one, carries a MAC.
+			chunks := size/cryptChunkSize + 1
+			if size > 0 && size%cryptChunkSize == 0 {
+				chunks--
+			}
+			if overhead := len(data) - size; overhead < chunks*secretbox.Overhead {
+				t.Errorf("%s, %d bytes: expected at least %d chunks, got %d bytes of overhead", scheme.name, size, chunks, overhead)
+			}
+
+			got, err := decryptBytes(data, scheme.decrypt)
+			if err != nil {
+				t.Errorf("%s, %d bytes: Decrypt failed: %s", scheme.name, size, err)
+				continue
+			}
+
+			if !bytes.Equal(got, plain) {
+				t.Errorf("%s, %d bytes: decrypted data differs from the original", scheme.name, size)
+			}
+		}
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	key, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := encryptBytes(t, []byte("data"), CryptOpts{Key: key})
+	if _, err := decryptBytes(data, CryptOpts{Key: otherKey}); err == nil {
+		t.Errorf("Decrypt succeeded with the wrong key")
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	key, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := randomBytes(t, 2*cryptChunkSize+100)
+	data := encryptBytes(t, plain, CryptOpts{Key: key})
+	if len(data) != cryptKeyHeaderSize+2*cryptBoxSize+100+secretbox.Overhead {
+		t.Fatalf("Unexpected size of encrypted data: %d", len(data))
+	}
+
+	header := data[:cryptKeyHeaderSize]
+	prefix := header[cryptKeyHeaderSize-cryptPrefixSize:]
+	chunk := func(i int) []byte {
+		start := cryptKeyHeaderSize + i*cryptBoxSize
+		end := start + cryptBoxSize
+		if end > len(data) {
+			end = len(data)
+		}
+		return data[start:end]
+	}
+
+	// seal builds encrypted data from the header and chunks of plain which
+	// are sealed with the given final flags.
+	seal := func(final ...bool) []byte {
+		out := append([]byte{}, header...)
+		for i, f := range final {
+			end := (i + 1) * cryptChunkSize
+			if end > len(plain) {
+				end = len(plain)
+			}
+			nonce := chunkNonce(prefix, uint64(i), f)
+			out = secretbox.Seal(out, plain[i*cryptChunkSize:end], &nonce, key)
+		}
+		return out
+	}
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	edited := append([]byte{}, data...)
+	edited[cryptKeyHeaderSize+cryptBoxSize+10] ^= 1
+
+	editedPrefix := append([]byte{}, data...)
+	editedPrefix[cryptKeyHeaderSize-1] ^= 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"final chunk dropped", join(header, chunk(0), chunk(1))},
+		{"final chunk cut off", data[:len(data)-1]},
+		{"middle chunk cut off", data[:cryptKeyHeaderSize+cryptBoxSize+10]},
+		{"header only", header},
+		{"chunks reordered", join(header, chunk(1), chunk(0), chunk(2))},
+		{"chunk duplicated", join(header, chunk(0), chunk(0), chunk(1), chunk(2))},
+		{"chunk edited", edited},
+		{"nonce prefix edited", editedPrefix},
+		{"data appended", join(data, []byte{0})},
+		{"final chunk not flagged", seal(false, false)},
+		{"non-final chunk flagged", seal(true, false)},
+		{"data after final chunk", seal(false, true, true)},
+	}
+
+	for _, test := range tests {
+		if _, err := decryptBytes(test.data, CryptOpts{Key: key}); err == nil {
+			t.Errorf("%s: Decrypt succeeded", test.name)
+		}
+	}
+
+	// The chunks sealed by seal are valid if the flags are right.
+	valid := seal(false, true)
+	got, err := decryptBytes(valid, CryptOpts{Key: key})
+	if err != nil {
+		t.Fatalf("Decrypt failed: %s", err)
+	}
+	if !bytes.Equal(got, plain[:2*cryptChunkSize]) {
+		t.Errorf("Decrypted data differs from the original")
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	const pass = "secret"
+	plain := randomBytes(t, 1000)
+	salt := randomBytes(t, cryptSaltSize)
+
+	secretKey, err := scryptParams{
+		Salt:      salt,
+		KDFParams: KDFParams{LogN: 14, R: 8, P: 1},
+	}.deriveKey(pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], randomBytes(t, len(nonce)))
+
+	// Data without a header is a single secretbox message.
+	legacy := append(append([]byte{}, salt...), nonce[:]...)
+	legacy = secretbox.Seal(legacy, plain, &nonce, secretKey)
+
+	// Version 1 of the chunked format only stored a salt.
+	prefix := randomBytes(t, cryptPrefixSize)
+	var chunked bytes.Buffer
+	chunked.Write(cryptMagic)
+	chunked.WriteByte(cryptVersionChunked)
+	chunked.Write(salt)
+	chunked.Write(prefix)
+	if err := encryptChunks(&chunked, bytes.NewReader(plain), secretKey, prefix); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no header", legacy},
+		{"version 1", chunked.Bytes()},
+	}
+
+	for _, test := range tests {
+		got, err := decryptBytes(test.data, CryptOpts{Pass: pass})
+		if err != nil {
+			t.Errorf("%s: Decrypt failed: %s", test.name, err)
+			continue
+		}
+
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: decrypted data differs from the original", test.name)
+		}
+
+		if _, err := decryptBytes(test.data, CryptOpts{Pass: "wrong"}); err == nil {
+			t.Errorf("%s: Decrypt succeeded with the wrong passphrase", test.name)
+		}
+	}
+}
+
+func TestRekeyHeader(t *testing.T) {
+	oldPublicKey, oldPrivateKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPublicKey, newPrivateKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := randomBytes(t, cryptChunkSize+100)
+	data := encryptBytes(t, plain, CryptOpts{Recipients: []*[32]byte{oldPublicKey}})
+
+	oldOpts := CryptOpts{Identities: []*[32]byte{oldPrivateKey}}
+	newOpts := CryptOpts{Recipients: []*[32]byte{newPublicKey}}
+
+	br := bufio.NewReader(bytes.NewReader(data))
+	header, oldSize, err := RekeyHeader(br, oldOpts, newOpts)
+	if err != nil {
+		t.Fatalf("RekeyHeader failed: %s", err)
+	}
+
+	h, err := readCryptHeader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldSize != len(h.marshal()) {
+		t.Errorf("Expected an old header of %d bytes, got %d", len(h.marshal()), oldSize)
+	}
+
+	if bytes.Equal(header, data[:oldSize]) {
+		t.Errorf("The header was not rewritten")
+	}
+
+	// Only the header is consumed from br, so the chunks which follow it
+	// can be copied as they are.
+	body, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data[oldSize:]) {
+		t.Errorf("RekeyHeader consumed more than the header")
+	}
+
+	rekeyed := append(append([]byte{}, header...), body...)
+	got, err := decryptBytes(rekeyed, CryptOpts{Identities: []*[32]byte{newPrivateKey}})
+	if err != nil {
+		t.Fatalf("Decrypt with the new key failed: %s", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Decrypted data differs from the original")
+	}
+
+	if _, err := decryptBytes(rekeyed, oldOpts); err == nil {
+		t.Errorf("Decrypt succeeded with the old key")
+	}
+
+	if _, _, err := RekeyHeader(bufio.NewReader(bytes.NewReader(data)), newOpts, newOpts); err == nil {
+		t.Errorf("RekeyHeader succeeded without the old key")
+	}
+}
+
+func TestRekeyHeaderNotWrapped(t *testing.T) {
+	key, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, privateKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyData := encryptBytes(t, []byte("data"), CryptOpts{Key: key})
+	recipientData := encryptBytes(t, []byte("data"), CryptOpts{Recipients: []*[32]byte{publicKey}})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		oldOpts CryptOpts
+		newOpts CryptOpts
+	}{
+		{"key file data", keyData, CryptOpts{Key: key}, CryptOpts{Recipients: []*[32]byte{publicKey}}},
+		{"new key file", recipientData, CryptOpts{Identities: []*[32]byte{privateKey}}, CryptOpts{Key: key}},
+		{"no header", []byte("data without a header"), CryptOpts{Pass: "secret"}, CryptOpts{Recipients: []*[32]byte{publicKey}}},
+	}
+
+	for _, test := range tests {
+		br := bufio.NewReader(bytes.NewReader(test.data))
+		header, oldSize, err := RekeyHeader(br, test.oldOpts, test.newOpts)
+		if err != nil || header != nil || oldSize != 0 {
+			t.Errorf("%s: expected no header, got %d bytes and error %v", test.name, len(header), err)
+		}
+
+		rest, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rest, test.data) {
+			t.Errorf("%s: RekeyHeader consumed data", test.name)
+		}
+	}
+}
+
+func TestRekey(t *testing.T) {
+	key, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := randomBytes(t, 2*cryptChunkSize)
+	data := encryptBytes(t, plain, CryptOpts{Key: key})
+
+	var rekeyed bytes.Buffer
+	if err := Rekey(&rekeyed, bytes.NewReader(data), CryptOpts{Key: key}, CryptOpts{Key: newKey}); err != nil {
+		t.Fatalf("Rekey failed: %s", err)
+	}
+
+	got, err := decryptBytes(rekeyed.Bytes(), CryptOpts{Key: newKey})
+	if err != nil {
+		t.Fatalf("Decrypt with the new key failed: %s", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Decrypted data differs from the original")
+	}
+
+	if _, err := decryptBytes(rekeyed.Bytes(), CryptOpts{Key: key}); err == nil {
+		t.Errorf("Decrypt succeeded with the old key")
+	}
+}
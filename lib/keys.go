@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Keys are stored as PEM blocks of the following types.
+const (
+	PEMPublicKey  = "LIMBO PUBLIC KEY"
+	PEMPrivateKey = "LIMBO PRIVATE KEY"
+	PEMSecretKey  = "LIMBO SECRET KEY"
+	PEMSigningKey = "LIMBO SIGNING KEY"
+	PEMVerifyKey  = "LIMBO VERIFY KEY"
+	PEMKeyShare   = "LIMBO KEY SHARE"
+)
+
+// GenerateKeyPair generates a Curve25519 key pair for public-key encryption.
+func GenerateKeyPair() (publicKey, privateKey *[32]byte, err error) {
+	publicKey, privateKey, err = box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to generate key pair: %s", err)
+	}
+
+	return publicKey, privateKey, nil
+}
+
+// GenerateSecretKey generates a random key for symmetric encryption.
+func GenerateSecretKey() (*[32]byte, error) {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, fmt.Errorf("Unable to generate key: %s", err)
+	}
+
+	return &key, nil
+}
+
+// GenerateSigningKey generates an Ed25519 key pair for signing images.
+func GenerateSigningKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to generate signing key: %s", err)
+	}
+
+	return publicKey, privateKey, nil
+}
+
+// MarshalKey encodes a key as a PEM block of the given type.
+func MarshalKey(blockType string, key []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  blockType,
+		Bytes: key,
+	})
+}
+
+// ParseKeys returns all keys of the given type found in PEM encoded data.
+func ParseKeys(blockType string, data []byte) ([]*[32]byte, error) {
+	blocks, err := parseKeyBlocks(blockType, data, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*[32]byte
+	for _, b := range blocks {
+		var key [32]byte
+		copy(key[:], b)
+		keys = append(keys, &key)
+	}
+
+	return keys, nil
+}
+
+// ParseSigningKey returns the first signing key found in PEM encoded data.
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	blocks, err := parseKeyBlocks(PEMSigningKey, data, ed25519.PrivateKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return ed25519.PrivateKey(blocks[0]), nil
+}
+
+// ParseVerifyKeys returns all verification keys found in PEM encoded data.
+func ParseVerifyKeys(data []byte) ([]ed25519.PublicKey, error) {
+	blocks, err := parseKeyBlocks(PEMVerifyKey, data, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ed25519.PublicKey
+	for _, b := range blocks {
+		keys = append(keys, ed25519.PublicKey(b))
+	}
+
+	return keys, nil
+}
+
+// parseKeyBlocks returns the content of all PEM blocks of the given type.
+// Each block must hold exactly size bytes.
+func parseKeyBlocks(blockType string, data []byte, size int) ([][]byte, error) {
+	var blocks [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != blockType {
+			continue
+		}
+
+		if len(block.Bytes) != size {
+			return nil, fmt.Errorf("Invalid %s: expected %d bytes, got %d", blockType, size, len(block.Bytes))
+		}
+
+		blocks = append(blocks, block.Bytes)
+	}
+
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("No %s found", blockType)
+	}
+
+	return blocks, nil
+}
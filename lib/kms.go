@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Key manager names.
+const (
+	KeyManagerVault    = "vault"
+	KeyManagerBarbican = "barbican"
+)
+
+// KeyManager wraps data keys with a key which never leaves an external key
+// manager, or which is only handed out to authorized clients.
+type KeyManager interface {
+	// Name identifies the key manager in the header of encrypted data.
+	Name() string
+
+	// KeyID is the ID of the key new data keys are wrapped with. It is
+	// empty if the key manager is only used to unwrap keys.
+	KeyID() string
+
+	// WrapKey encrypts a data key with the key KeyID refers to.
+	WrapKey(key *[32]byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key which was wrapped with the key keyID.
+	UnwrapKey(keyID string, wrapped []byte) (*[32]byte, error)
+}
+
+// envelopeParams are the KDF parameters of data whose key is wrapped by a
+// key manager. The key ID is stored, so only access to the key manager is
+// needed to decrypt the data.
+//
+//	len(1) | key manager | len(2) | key ID | wrapped key
+type envelopeParams struct {
+	KeyManager string
+	KeyID      string
+	WrappedKey []byte
+}
+
+func (p envelopeParams) marshal() ([]byte, error) {
+	if len(p.KeyManager) > 0xff || len(p.KeyID) > 0xffff {
+		return nil, fmt.Errorf("Key manager or key ID is too long")
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(byte(len(p.KeyManager)))
+	b.WriteString(p.KeyManager)
+	binary.Write(&b, binary.BigEndian, uint16(len(p.KeyID)))
+	b.WriteString(p.KeyID)
+	b.Write(p.WrappedKey)
+
+	return b.Bytes(), nil
+}
+
+func parseEnvelopeParams(b []byte) (*envelopeParams, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0])+2 {
+		return nil, fmt.Errorf("Encrypted data is malformed")
+	}
+
+	p := &envelopeParams{
+		KeyManager: string(b[1 : 1+b[0]]),
+	}
+	b = b[1+b[0]:]
+
+	length := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < length {
+		return nil, fmt.Errorf("Encrypted data is malformed")
+	}
+
+	p.KeyID = string(b[:length])
+	p.WrappedKey = b[length:]
+
+	return p, nil
+}
+
+// wrapEnvelopeKey has the key manager wrap a data key and returns the KDF
+// parameters for the header.
+func wrapEnvelopeKey(secretKey *[32]byte, km KeyManager) ([]byte, error) {
+	if km.KeyID() == "" {
+		return nil, fmt.Errorf("A key is required to encrypt with %s", km.Name())
+	}
+
+	wrapped, err := km.WrapKey(secretKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to wrap data key with %s: %s", km.Name(), err)
+	}
+
+	return envelopeParams{
+		KeyManager: km.Name(),
+		KeyID:      km.KeyID(),
+		WrappedKey: wrapped,
+	}.marshal()
+}
+
+// unwrapEnvelopeKey recovers a data key wrapped by wrapEnvelopeKey.
+func unwrapEnvelopeKey(params []byte, km KeyManager) (*[32]byte, error) {
+	p, err := parseEnvelopeParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if km == nil || km.Name() != p.KeyManager {
+		return nil, fmt.Errorf("Data key is held by %[1]s. Use --kms %[1]s to decrypt it", p.KeyManager)
+	}
+
+	secretKey, err := km.UnwrapKey(p.KeyID, p.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unwrap data key with %s key %s: %s", p.KeyManager, p.KeyID, err)
+	}
+
+	return secretKey, nil
+}
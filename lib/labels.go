@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LabelsMetadataKey is the object metadata the labels of an image are
+// stored in, next to its manifest.
+const LabelsMetadataKey = "Limbo-Labels"
+
+// LabelDoNotDelete marks exports prune never removes.
+const LabelDoNotDelete = "do-not-delete"
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^[A-Za-z0-9._:/+-]*$`)
+)
+
+// Labels mark exports, such as release=2026.10 or pre-upgrade. A label
+// without a value has an empty one.
+type Labels map[string]string
+
+// ParseLabels parses labels given as key=value or key.
+func ParseLabels(specs []string) (Labels, error) {
+	labels := Labels{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		key := parts[0]
+		var value string
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+
+		if err := validateLabel(key, value); err != nil {
+			return nil, err
+		}
+
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
+// ParseLabelString parses labels as String formats them.
+func ParseLabelString(s string) (Labels, error) {
+	if s == "" {
+		return Labels{}, nil
+	}
+
+	return ParseLabels(strings.Split(s, ","))
+}
+
+// String formats labels as a sorted, comma separated list.
+func (l Labels) String() string {
+	var specs []string
+	for _, key := range l.Keys() {
+		if l[key] == "" {
+			specs = append(specs, key)
+		} else {
+			specs = append(specs, key+"="+l[key])
+		}
+	}
+
+	return strings.Join(specs, ",")
+}
+
+// Keys returns the sorted keys of the labels.
+func (l Labels) Keys() []string {
+	var keys []string
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func validateLabel(key, value string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("Invalid label key: %q", key)
+	}
+
+	if !labelValuePattern.MatchString(value) {
+		return fmt.Errorf("Invalid value of label %s: %q", key, value)
+	}
+
+	return nil
+}
+
+// Selector selects exports by their labels. It is a comma separated list
+// of requirements, all of which must be met: key=value, key!=value, key
+// for a label which is set and !key for a label which is not.
+type Selector []selectorRequirement
+
+type selectorRequirement struct {
+	key    string
+	value  string
+	op     string
+	exists bool
+}
+
+// ParseSelector parses a selector.
+func ParseSelector(s string) (Selector, error) {
+	var selector Selector
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		r := selectorRequirement{exists: true}
+		switch {
+		case strings.Contains(spec, "!="):
+			parts := strings.SplitN(spec, "!=", 2)
+			r.key, r.value, r.op = parts[0], parts[1], "!="
+		case strings.Contains(spec, "="):
+			parts := strings.SplitN(strings.Replace(spec, "==", "=", 1), "=", 2)
+			r.key, r.value, r.op = parts[0], parts[1], "="
+		case strings.HasPrefix(spec, "!"):
+			r.key, r.exists = spec[1:], false
+		default:
+			r.key = spec
+		}
+
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if err := validateLabel(r.key, r.value); err != nil {
+			return nil, fmt.Errorf("Invalid selector %q: %s", s, err)
+		}
+
+		selector = append(selector, r)
+	}
+
+	return selector, nil
+}
+
+// Matches determines if labels meet all requirements of the selector.
+func (s Selector) Matches(labels Labels) bool {
+	for _, r := range s {
+		value, ok := labels[r.key]
+		switch r.op {
+		case "=":
+			if !ok || value != r.value {
+				return false
+			}
+		case "!=":
+			if ok && value == r.value {
+				return false
+			}
+		default:
+			if ok != r.exists {
+				return false
+			}
+		}
+	}
+
+	return true
+}
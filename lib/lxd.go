@@ -1,10 +1,14 @@
 package lib
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
 
 	lxd "github.com/lxc/lxd/client"
 	lxd_config "github.com/lxc/lxd/lxc/config"
@@ -16,6 +20,7 @@ type LXDConfig struct {
 	Config          *lxd_config.Config
 	RemoteName      string
 	ConfigDirectory string
+	Retry           RetryOpts
 }
 
 func NewLXDConfig(c LXDConfig) (LXDConfig, error) {
@@ -37,7 +42,16 @@ func (r LXDConfig) GetContainerServer() (lxd.ContainerServer, error) {
 		r.getConfig()
 	}
 
-	return r.Config.GetContainerServer(r.RemoteName)
+	lxdServer, err := r.Config.GetContainerServer(r.RemoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.setupRetry(lxdServer); err != nil {
+		return nil, err
+	}
+
+	return lxdServer, nil
 }
 
 func (r LXDConfig) GetImageServer() (lxd.ImageServer, error) {
@@ -45,7 +59,94 @@ func (r LXDConfig) GetImageServer() (lxd.ImageServer, error) {
 		r.getConfig()
 	}
 
-	return r.Config.GetImageServer(r.RemoteName)
+	lxdImageServer, err := r.Config.GetImageServer(r.RemoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.setupRetry(lxdImageServer); err != nil {
+		return nil, err
+	}
+
+	return lxdImageServer, nil
+}
+
+// setupRetry configures the HTTP client of an LXD connection to retry
+// failed GET requests.
+func (r LXDConfig) setupRetry(lxdServer lxd.ImageServer) error {
+	if r.Retry.Retries <= 0 {
+		return nil
+	}
+
+	httpClient, err := lxdServer.GetHTTPClient()
+	if err != nil {
+		return fmt.Errorf("Unable to configure LXD retries: %s", err)
+	}
+
+	httpClient.Transport = newRetryTransport(httpClient.Transport, r.Retry, "GET", "HEAD")
+	return nil
+}
+
+// waitOperation waits for an LXD operation to finish. The wait relies on
+// the LXD events websocket, so if the websocket is dropped before the
+// operation has finished, the operation is polled instead.
+func (r LXDConfig) waitOperation(lxdServer lxd.ContainerServer, op *lxd.Operation) error {
+	err := op.Wait()
+	if err == nil || op.StatusCode.IsFinal() {
+		return err
+	}
+
+	r.Retry.debugf("Lost connection while waiting for operation %s: %s", op.ID, err)
+	state, err := r.pollOperation(lxdServer, op.ID)
+	if err != nil {
+		return err
+	}
+
+	op.Operation = *state
+	if op.Err != "" {
+		return fmt.Errorf("%s", op.Err)
+	}
+
+	return nil
+}
+
+// pollOperation polls an LXD operation until it has finished.
+func (r LXDConfig) pollOperation(lxdServer lxd.ContainerServer, id string) (*lxd_api.Operation, error) {
+	for {
+		op, _, err := lxdServer.GetOperation(id)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get operation %s: %s", id, err)
+		}
+
+		if op.StatusCode.IsFinal() {
+			return op, nil
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// LXDDescribeImage returns a manifest describing an image on the LXD
+// server, with its fingerprint, architecture and properties filled in.
+func LXDDescribeImage(lxdConfig LXDConfig, fingerprint string) (*Manifest, error) {
+	lxdServer, err := lxdConfig.GetImageServer()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to LXD image server: %s", err)
+	}
+
+	image, _, err := lxdServer.GetImage(fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get image %s: %s", fingerprint, err)
+	}
+
+	m := &Manifest{
+		Version:      ManifestVersion,
+		Fingerprint:  image.Fingerprint,
+		Architecture: image.Architecture,
+		Properties:   image.Properties,
+	}
+
+	return m, nil
 }
 
 type LXDPublishOpts struct {
@@ -93,7 +194,7 @@ func LXDPublishImage(lxdConfig LXDConfig, opts LXDPublishOpts) (*LXDPublishResul
 					return nil, fmt.Errorf("Unable to update container: %s", err)
 				}
 
-				if err := op.Wait(); err != nil {
+				if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
 					return nil, fmt.Errorf("Problem waiting for container to update: %s", err)
 				}
 
@@ -114,7 +215,7 @@ func LXDPublishImage(lxdConfig LXDConfig, opts LXDPublishOpts) (*LXDPublishResul
 				return nil, err
 			}
 
-			if err := op.Wait(); err != nil {
+			if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
 				return nil, fmt.Errorf("Problem waiting for container to stop: %s", err)
 			}
 			defer func() {
@@ -124,7 +225,7 @@ func LXDPublishImage(lxdConfig LXDConfig, opts LXDPublishOpts) (*LXDPublishResul
 					panic(err)
 				}
 
-				if err := op.Wait(); err != nil {
+				if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
 					panic(err)
 				}
 			}()
@@ -136,7 +237,7 @@ func LXDPublishImage(lxdConfig LXDConfig, opts LXDPublishOpts) (*LXDPublishResul
 					return nil, fmt.Errorf("Unable to update container: %s", err)
 				}
 
-				if err := op.Wait(); err != nil {
+				if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
 					return nil, fmt.Errorf("Problem waiting for container to update: %s", err)
 				}
 			}
@@ -166,7 +267,7 @@ func LXDPublishImage(lxdConfig LXDConfig, opts LXDPublishOpts) (*LXDPublishResul
 		return nil, fmt.Errorf("Unable to create image from container: %s", err)
 	}
 
-	if err := op.Wait(); err != nil {
+	if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
 		return nil, fmt.Errorf("Problem waiting for image to create: %s", err)
 	}
 
@@ -178,100 +279,121 @@ func LXDPublishImage(lxdConfig LXDConfig, opts LXDPublishOpts) (*LXDPublishResul
 	return p, nil
 }
 
-type LXDDownloadOpts struct {
-	Name        string
-	TmpDir      string
+type LXDStreamOpts struct {
 	Fingerprint string
+
+	// MetaFile receives the meta tarball of a split image or the whole
+	// image if it is a unified image.
+	MetaFile io.WriteCloser
+
+	// RootfsFile receives the rootfs tarball of a split image.
+	RootfsFile io.WriteCloser
 }
 
-type LXDDownloadResult struct {
-	MetaFilename   string
-	RootfsFilename string
+type LXDStreamResult struct {
+	MetaName   string
+	MetaSize   int64
+	RootfsName string
+	RootfsSize int64
 }
 
 // This is a loose re-implementation of lxc image export:
 // https://github.com/lxc/lxd/blob/master/lxc/image.go
-// This will download an image to a temporary directory.
-func LXDDownloadImage(lxdConfig LXDConfig, opts LXDDownloadOpts) (*LXDDownloadResult, error) {
+// Instead of saving the image to files, it is streamed to the given
+// writers. LXD sends the meta tarball before the rootfs tarball, so the meta
+// writer is closed as soon as the rootfs tarball starts. Both writers are
+// always closed, and closed with an error if the download failed. Errors
+// which might not occur again, such as a lost connection, are returned as
+// ErrTransient.
+func LXDStreamImage(lxdConfig LXDConfig, opts LXDStreamOpts) (*LXDStreamResult, error) {
 	lxdServer, err := lxdConfig.GetContainerServer()
 	if err != nil {
+		closeWithError(opts.MetaFile, err)
+		closeWithError(opts.RootfsFile, err)
 		return nil, fmt.Errorf("Unable to connect to LXD container server: %s", err)
 	}
 
-	targetMeta := filepath.Join(opts.TmpDir, opts.Name)
-	destMeta, err := os.Create(targetMeta)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to create temporary meta file: %s", err)
-	}
-
-	targetRootfs := filepath.Join(opts.TmpDir, opts.Name+".root")
-	destRoot, err := os.Create(targetRootfs)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to create temporary root file: %s", err)
-	}
+	meta := &streamFile{w: opts.MetaFile}
+	rootfs := &streamFile{w: opts.RootfsFile, before: meta}
 
 	imageDownloadReq := lxd.ImageFileRequest{
-		MetaFile:   destMeta,
-		RootfsFile: destRoot,
+		MetaFile:   meta,
+		RootfsFile: rootfs,
 	}
 
 	resp, err := lxdServer.GetImageFile(opts.Fingerprint, imageDownloadReq)
+
+	// Closing the writers waits for the data to be consumed, which can
+	// fail as well.
+	metaErr := meta.close(err)
+	rootfsErr := rootfs.close(err)
+
 	if err != nil {
-		os.Remove(targetMeta)
-		os.Remove(targetRootfs)
-		return nil, fmt.Errorf("Unable to download image: %s", err)
+		return nil, transientError(err, fmt.Errorf("Unable to download image: %s", err))
 	}
 
-	uploadRoot := true
-	if resp.RootfsSize == 0 {
-		uploadRoot = false
-		err := os.Remove(targetRootfs)
-		if err != nil {
-			os.Remove(targetMeta)
-			os.Remove(targetRootfs)
-			return nil, fmt.Errorf("Unable to remove temporary root file: %s", err)
-		}
+	if metaErr != nil {
+		return nil, transientError(metaErr, fmt.Errorf("Unable to process meta file: %s", metaErr))
 	}
 
-	if resp.MetaName != "" {
-		newName := filepath.Join(opts.TmpDir, resp.MetaName)
-		err := os.Rename(targetMeta, newName)
-		if err != nil {
-			os.Remove(targetMeta)
-			os.Remove(targetRootfs)
-			return nil, fmt.Errorf("Unable to rename meta file: %s", err)
-		}
-		targetMeta = newName
+	if rootfsErr != nil {
+		return nil, transientError(rootfsErr, fmt.Errorf("Unable to process rootfs file: %s", rootfsErr))
 	}
 
-	if resp.RootfsSize > 0 && resp.RootfsName != "" {
-		newName := filepath.Join(opts.TmpDir, resp.RootfsName)
-		err := os.Rename(targetRootfs, newName)
-		if err != nil {
-			os.Remove(targetMeta)
-			os.Remove(targetRootfs)
-			return nil, fmt.Errorf("Unable to rename root file: %s", err)
+	s := &LXDStreamResult{
+		MetaName:   resp.MetaName,
+		MetaSize:   resp.MetaSize,
+		RootfsName: resp.RootfsName,
+		RootfsSize: resp.RootfsSize,
+	}
+
+	return s, nil
+}
+
+// streamFile adapts an io.WriteCloser to the io.WriteSeeker which the LXD
+// client expects. The LXD client never seeks when downloading an image.
+type streamFile struct {
+	w      io.WriteCloser
+	before *streamFile
+	closed bool
+	err    error
+}
+
+func (f *streamFile) Write(p []byte) (int, error) {
+	// The first write to this file means the previous file is complete.
+	if f.before != nil && !f.before.closed {
+		if err := f.before.close(nil); err != nil {
+			return 0, err
 		}
-		targetRootfs = newName
 	}
 
-	d := &LXDDownloadResult{
-		MetaFilename: targetMeta,
+	return f.w.Write(p)
+}
+
+func (f *streamFile) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence == io.SeekEnd {
+		return 0, fmt.Errorf("Unable to seek in a stream")
 	}
 
-	if uploadRoot {
-		d.RootfsFilename = targetRootfs
+	return 0, nil
+}
+
+func (f *streamFile) close(err error) error {
+	if !f.closed {
+		f.closed = true
+		f.err = closeWithError(f.w, err)
 	}
 
-	return d, nil
+	return f.err
 }
 
 type LXDImportOpts struct {
-	Aliases        []string
-	Name           string
-	MetaFilename   string
-	RootfsFilename string
-	TmpDir         string
+	Aliases    []string
+	Name       string
+	MetaFile   io.Reader
+	MetaName   string
+	RootfsFile io.Reader
+	RootfsName string
 }
 
 type LXDImportResult struct {
@@ -280,6 +402,9 @@ type LXDImportResult struct {
 
 // This is a loose re-implementation of "lxc import".
 // https://github.com/lxc/lxd/blob/master/lxc/image.go
+// The image is read from the given readers while it is uploaded to LXD.
+// If the image was stored but could not be named, its fingerprint is
+// returned together with the error, so it can be removed.
 func LXDImportImage(lxdConfig LXDConfig, opts LXDImportOpts) (*LXDImportResult, error) {
 	lxdServer, err := lxdConfig.GetContainerServer()
 	if err != nil {
@@ -287,44 +412,50 @@ func LXDImportImage(lxdConfig LXDConfig, opts LXDImportOpts) (*LXDImportResult,
 	}
 
 	image := lxd_api.ImagesPost{}
+	image.Filename = opts.Name
 
-	var meta io.ReadCloser
-	var rootfs io.ReadCloser
+	var fingerprint string
+	if opts.RootfsFile == nil {
+		// A unified image is passed through by the LXD client.
+		args := &lxd.ImageCreateArgs{
+			MetaFile: opts.MetaFile,
+			MetaName: opts.MetaName,
+		}
 
-	meta, err = os.Open(opts.MetaFilename)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to open meta file %s: %s", opts.MetaFilename, err)
-	}
-	defer meta.Close()
+		op, err := lxdServer.CreateImage(image, args)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create image: %s", err)
+		}
 
-	if opts.RootfsFilename != "" {
-		rootfs, err = os.Open(opts.RootfsFilename)
+		err = lxdConfig.waitOperation(lxdServer, op)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to open rootfs file %s: %s", opts.RootfsFilename, err)
+			return nil, fmt.Errorf("Error saving image: %s", err)
 		}
-		defer rootfs.Close()
-	}
 
-	args := &lxd.ImageCreateArgs{
-		MetaFile:   meta,
-		MetaName:   filepath.Base(opts.MetaFilename),
-		RootfsFile: rootfs,
-		RootfsName: filepath.Base(opts.RootfsFilename),
-	}
+		fingerprint = op.Metadata["fingerprint"].(string)
+	} else {
+		// The LXD client saves split images to a temporary file before
+		// uploading them, so they are uploaded here instead.
+		opID, err := lxdConfig.createSplitImage(lxdServer, image, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create image: %s", err)
+		}
 
-	image.Filename = opts.Name
+		op, err := lxdConfig.pollOperation(lxdServer, opID)
+		if err != nil {
+			return nil, fmt.Errorf("Error saving image: %s", err)
+		}
 
-	op, err := lxdServer.CreateImage(image, args)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to create image: %s", err)
-	}
+		if op.Err != "" {
+			return nil, fmt.Errorf("Error saving image: %s", op.Err)
+		}
 
-	err = op.Wait()
-	if err != nil {
-		return nil, fmt.Errorf("Error saving image: %s", err)
+		fingerprint = op.Metadata["fingerprint"].(string)
 	}
 
-	fingerprint := op.Metadata["fingerprint"].(string)
+	r := &LXDImportResult{
+		Fingerprint: fingerprint,
+	}
 
 	// Set the name and aliases of the image
 	opts.Aliases = append(opts.Aliases, opts.Name)
@@ -333,13 +464,326 @@ func LXDImportImage(lxdConfig LXDConfig, opts LXDImportOpts) (*LXDImportResult,
 		aliasPost.Name = v
 		aliasPost.Target = fingerprint
 		if err := lxdServer.CreateImageAlias(aliasPost); err != nil {
-			return nil, fmt.Errorf("Unable to set alias %s on %s", v, opts.Name)
+			return r, fmt.Errorf("Unable to set alias %s on %s", v, opts.Name)
 		}
 	}
 
-	r := &LXDImportResult{
-		Fingerprint: fingerprint,
+	return r, nil
+}
+
+// createSplitImage uploads the meta and rootfs tarballs of a split image as
+// a multipart request which is streamed from the readers. The ID of the
+// resulting operation is returned.
+func (r LXDConfig) createSplitImage(lxdServer lxd.ContainerServer, image lxd_api.ImagesPost, opts LXDImportOpts) (string, error) {
+	httpClient, err := lxdServer.GetHTTPClient()
+	if err != nil {
+		return "", err
 	}
 
-	return r, nil
+	remote, ok := r.Config.Remotes[r.RemoteName]
+	if !ok {
+		return "", fmt.Errorf("The remote \"%s\" doesn't exist", r.RemoteName)
+	}
+
+	host := remote.Addr
+	if strings.HasPrefix(host, "unix:") {
+		host = "http://unix.socket"
+	}
+
+	body, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			fw, err := w.CreateFormFile("metadata", opts.MetaName)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(fw, opts.MetaFile); err != nil {
+				return err
+			}
+
+			fw, err = w.CreateFormFile("rootfs", opts.RootfsName)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(fw, opts.RootfsFile); err != nil {
+				return err
+			}
+
+			return w.Close()
+		}()
+
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest("POST", host+"/1.0/images", body)
+	if err != nil {
+		body.Close()
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if image.Filename != "" {
+		req.Header.Set("X-LXD-filename", image.Filename)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	response := lxd_api.Response{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("Unable to parse response: %s", err)
+	}
+
+	if response.Type == lxd_api.ErrorResponse {
+		return "", fmt.Errorf("%s", response.Error)
+	}
+
+	op, err := response.MetadataAsOperation()
+	if err != nil {
+		return "", err
+	}
+
+	return op.ID, nil
+}
+
+type LXDLaunchOpts struct {
+	Name        string
+	Fingerprint string
+
+	// Profiles are applied to the container. If none are given, LXD
+	// applies the default profile.
+	Profiles []string
+
+	// Timeout is how long to wait for the container to be running.
+	Timeout time.Duration
+}
+
+// LXDLaunchContainer creates an ephemeral container from an image, starts
+// it and waits until it is running.
+func LXDLaunchContainer(lxdConfig LXDConfig, opts LXDLaunchOpts) error {
+	lxdServer, err := lxdConfig.GetContainerServer()
+	if err != nil {
+		return fmt.Errorf("Unable to connect to LXD container server: %s", err)
+	}
+
+	createReq := lxd_api.ContainersPost{
+		Name: opts.Name,
+		Source: lxd_api.ContainerSource{
+			Type:        "image",
+			Fingerprint: opts.Fingerprint,
+		},
+	}
+	createReq.Ephemeral = true
+	createReq.Profiles = opts.Profiles
+
+	op, err := lxdServer.CreateContainer(createReq)
+	if err != nil {
+		return fmt.Errorf("Unable to create container: %s", err)
+	}
+
+	if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
+		return fmt.Errorf("Problem waiting for container to create: %s", err)
+	}
+
+	startReq := lxd_api.ContainerStatePut{
+		Action:  "start",
+		Timeout: -1,
+	}
+
+	op, err = lxdServer.UpdateContainerState(opts.Name, startReq, "")
+	if err != nil {
+		return fmt.Errorf("Unable to start container: %s", err)
+	}
+
+	if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
+		return fmt.Errorf("Problem waiting for container to start: %s", err)
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	for {
+		state, _, err := lxdServer.GetContainerState(opts.Name)
+		if err != nil {
+			return fmt.Errorf("Unable to get container state: %s", err)
+		}
+
+		if state.StatusCode == lxd_api.Running {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Container %s is %s instead of running after %s", opts.Name, state.Status, opts.Timeout)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+type LXDExecOpts struct {
+	Name    string
+	Command []string
+
+	// Stdout and Stderr receive the output of the command. If they are nil,
+	// the output is discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// LXDExecContainer runs a command in a container without input and returns
+// its exit code.
+func LXDExecContainer(lxdConfig LXDConfig, opts LXDExecOpts) (int, error) {
+	lxdServer, err := lxdConfig.GetContainerServer()
+	if err != nil {
+		return -1, fmt.Errorf("Unable to connect to LXD container server: %s", err)
+	}
+
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	execReq := lxd_api.ContainerExecPost{
+		Command:   opts.Command,
+		WaitForWS: true,
+	}
+
+	dataDone := make(chan bool)
+	execArgs := &lxd.ContainerExecArgs{
+		Stdin:    ioutil.NopCloser(strings.NewReader("")),
+		Stdout:   nopWriteCloser{stdout},
+		Stderr:   nopWriteCloser{stderr},
+		DataDone: dataDone,
+	}
+
+	op, err := lxdServer.ExecContainer(opts.Name, execReq, execArgs)
+	if err != nil {
+		return -1, fmt.Errorf("Unable to run command: %s", err)
+	}
+
+	if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
+		return -1, fmt.Errorf("Problem waiting for command to finish: %s", err)
+	}
+
+	// Wait for the output to be written.
+	<-dataDone
+
+	code, ok := op.Metadata["return"].(float64)
+	if !ok {
+		return -1, fmt.Errorf("LXD did not return the exit code of the command")
+	}
+
+	return int(code), nil
+}
+
+// nopWriteCloser keeps the LXD client from closing writers it is given,
+// such as os.Stdout.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// LXDDeleteContainer stops a container and deletes it. Ephemeral containers
+// are deleted by LXD once they are stopped. A container which does not
+// exist is ignored.
+func LXDDeleteContainer(lxdConfig LXDConfig, name string) error {
+	lxdServer, err := lxdConfig.GetContainerServer()
+	if err != nil {
+		return fmt.Errorf("Unable to connect to LXD container server: %s", err)
+	}
+
+	if exists, err := lxdContainerExists(lxdServer, name); err != nil || !exists {
+		return err
+	}
+
+	state, _, err := lxdServer.GetContainerState(name)
+	if err != nil {
+		return fmt.Errorf("Unable to get container state: %s", err)
+	}
+
+	if state.StatusCode != lxd_api.Stopped {
+		stopReq := lxd_api.ContainerStatePut{
+			Action:  "stop",
+			Timeout: -1,
+			Force:   true,
+		}
+
+		op, err := lxdServer.UpdateContainerState(name, stopReq, "")
+		if err != nil {
+			return fmt.Errorf("Unable to stop container: %s", err)
+		}
+
+		if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
+			return fmt.Errorf("Problem waiting for container to stop: %s", err)
+		}
+	}
+
+	if exists, err := lxdContainerExists(lxdServer, name); err != nil || !exists {
+		return err
+	}
+
+	op, err := lxdServer.DeleteContainer(name)
+	if err != nil {
+		return fmt.Errorf("Unable to delete container: %s", err)
+	}
+
+	if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
+		return fmt.Errorf("Problem waiting for container to delete: %s", err)
+	}
+
+	return nil
+}
+
+func lxdContainerExists(lxdServer lxd.ContainerServer, name string) (bool, error) {
+	names, err := lxdServer.GetContainerNames()
+	if err != nil {
+		return false, fmt.Errorf("Unable to list containers: %s", err)
+	}
+
+	return lxd_shared.StringInSlice(name, names), nil
+}
+
+// LXDImageExists determines if LXD has an image with the given fingerprint.
+func LXDImageExists(lxdConfig LXDConfig, fingerprint string) (bool, error) {
+	lxdServer, err := lxdConfig.GetContainerServer()
+	if err != nil {
+		return false, fmt.Errorf("Unable to connect to LXD container server: %s", err)
+	}
+
+	fingerprints, err := lxdServer.GetImageFingerprints()
+	if err != nil {
+		return false, fmt.Errorf("Unable to list images: %s", err)
+	}
+
+	return lxd_shared.StringInSlice(fingerprint, fingerprints), nil
+}
+
+// LXDDeleteImage deletes an image together with its aliases.
+func LXDDeleteImage(lxdConfig LXDConfig, fingerprint string) error {
+	lxdServer, err := lxdConfig.GetContainerServer()
+	if err != nil {
+		return fmt.Errorf("Unable to connect to LXD container server: %s", err)
+	}
+
+	op, err := lxdServer.DeleteImage(fingerprint)
+	if err != nil {
+		return fmt.Errorf("Unable to delete image: %s", err)
+	}
+
+	if err := lxdConfig.waitOperation(lxdServer, op); err != nil {
+		return fmt.Errorf("Problem waiting for image to delete: %s", err)
+	}
+
+	return nil
 }
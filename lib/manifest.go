@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"strings"
+	"time"
+)
+
+// ManifestVersion is the version of the manifest format.
+const ManifestVersion = 1
+
+// Roles of the objects of an image.
+const (
+	ManifestRoleMeta   = "meta"
+	ManifestRoleRootfs = "rootfs"
+)
+
+// Manifest describes an exported image, so it can be listed, inspected and
+// imported without downloading or guessing from object names.
+type Manifest struct {
+	Version      int               `json:"version"`
+	Image        string            `json:"image"`
+	Source       ManifestSource    `json:"source"`
+	Fingerprint  string            `json:"fingerprint"`
+	Architecture string            `json:"architecture,omitempty"`
+	Properties   map[string]string `json:"properties,omitempty"`
+	Compression  string            `json:"compression,omitempty"`
+	Labels       Labels            `json:"labels,omitempty"`
+	Objects      []ManifestObject  `json:"objects"`
+	Encryption   string            `json:"encryption"`
+	Created      time.Time         `json:"created"`
+	Hostname     string            `json:"hostname"`
+	LimboVersion string            `json:"limbo_version"`
+
+	// Encrypted is set by SwiftReadManifest if the manifest object itself
+	// is encrypted.
+	Encrypted bool `json:"-"`
+}
+
+// ManifestSource is the LXD resource an image was exported from.
+type ManifestSource struct {
+	Remote string `json:"remote"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+}
+
+// ManifestObject describes an object of an image. Size and SHA256 are those
+// of the data stored in Swift, which is encrypted if the image is.
+type ManifestObject struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Filename string `json:"filename,omitempty"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// Object returns the object with the given role, or nil if the image has
+// no such object.
+func (m Manifest) Object(role string) *ManifestObject {
+	for i := range m.Objects {
+		if m.Objects[i].Role == role {
+			return &m.Objects[i]
+		}
+	}
+
+	return nil
+}
+
+// Size returns the total size of the objects of the image.
+func (m Manifest) Size() int64 {
+	var size int64
+	for _, o := range m.Objects {
+		size += o.Size
+	}
+
+	return size
+}
+
+// Compression guesses the compression algorithm of an image from the name
+// of one of its files, as LXD names them.
+func Compression(filename string) string {
+	suffixes := []struct {
+		suffix      string
+		compression string
+	}{
+		{".tar.gz", "gzip"},
+		{".tgz", "gzip"},
+		{".tar.bz2", "bzip2"},
+		{".tar.lzma", "lzma"},
+		{".tar.xz", "xz"},
+		{".squashfs", "squashfs"},
+		{".tar", "none"},
+	}
+
+	for _, s := range suffixes {
+		if strings.HasSuffix(filename, s.suffix) {
+			return s.compression
+		}
+	}
+
+	return ""
+}
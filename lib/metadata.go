@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	lxd_api "github.com/lxc/lxd/shared/api"
+	"gopkg.in/yaml.v2"
+)
+
+// ReadImageMetadata reads metadata.yaml from the meta tarball of a split
+// image or from a unified image tarball, which may be compressed. Reading
+// stops as soon as metadata.yaml was found, which LXD puts at the start of
+// a tarball, so the rest of a unified tarball is not read.
+func ReadImageMetadata(r io.Reader) (*lxd_api.ImageMetadata, error) {
+	plain, done, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	tr := tar.NewReader(plain)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("Image has no metadata.yaml")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read image tarball: %s", err)
+		}
+
+		if strings.TrimPrefix(hdr.Name, "./") == "metadata.yaml" {
+			return parseImageMetadata(tr)
+		}
+	}
+}
+
+// CheckImageFile reads an image file to its end and checks its structure.
+// A meta tarball or a unified tarball must hold a valid metadata.yaml. A
+// rootfs is either a tarball or a squashfs image, of which only the magic
+// number is checked.
+func CheckImageFile(r io.Reader, meta bool) error {
+	br := bufio.NewReader(r)
+
+	// Whatever is left is read, so callers which hash r see all of it.
+	defer io.Copy(ioutil.Discard, br)
+
+	if magic, _ := br.Peek(4); !meta && bytes.Equal(magic, []byte("hsqs")) {
+		return nil
+	}
+
+	plain, done, err := decompress(br)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	var hasMetadata bool
+	tr := tar.NewReader(plain)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("Damaged image tarball: %s", err)
+		}
+
+		if meta && strings.TrimPrefix(hdr.Name, "./") == "metadata.yaml" {
+			if _, err := parseImageMetadata(tr); err != nil {
+				return err
+			}
+			hasMetadata = true
+		}
+
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return fmt.Errorf("Damaged image tarball: %s", err)
+		}
+	}
+
+	// Reading the rest of the compressed data checks its trailer.
+	if _, err := io.Copy(ioutil.Discard, plain); err != nil {
+		return fmt.Errorf("Damaged image tarball: %s", err)
+	}
+
+	if meta && !hasMetadata {
+		return fmt.Errorf("Image has no metadata.yaml")
+	}
+
+	return nil
+}
+
+func parseImageMetadata(r io.Reader) (*lxd_api.ImageMetadata, error) {
+	raw, err := ioutil.ReadAll(io.LimitReader(r, 1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read metadata.yaml: %s", err)
+	}
+
+	var metadata lxd_api.ImageMetadata
+	if err := yaml.Unmarshal(raw, &metadata); err != nil {
+		return nil, fmt.Errorf("Unable to parse metadata.yaml: %s", err)
+	}
+
+	return &metadata, nil
+}
+
+// decompress detects the compression of r and returns a reader for the
+// uncompressed data. The returned function releases the decompressor. Like
+// LXD, xz and lzma are decompressed with the xz tool.
+func decompress(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to decompress image: %s", err)
+		}
+		return gr, func() { gr.Close() }, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), func() {}, nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0}):
+		return execDecompress(br, "xz", "-dc")
+	case bytes.HasPrefix(magic, []byte{0x5d, 0, 0}):
+		return execDecompress(br, "xz", "--format=lzma", "-dc")
+	}
+
+	return br, func() {}, nil
+}
+
+// execDecompress pipes r through an external decompressor. Once its output
+// was read to the end, the exit status of the process is returned in place
+// of io.EOF, so errors such as a bad checksum or truncated input are not
+// lost. If the output was not read fully, the process is killed when it is
+// released.
+func execDecompress(r io.Reader, name string, args ...string) (io.Reader, func(), error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = r
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("Unable to run %s to decompress image: %s", name, err)
+	}
+
+	er := &execReader{
+		name:   name,
+		cmd:    cmd,
+		out:    out,
+		stderr: &stderr,
+	}
+
+	done := func() {
+		if !er.waited {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}
+
+	return er, done, nil
+}
+
+// execReader reads the output of a decompressor and waits for it to exit
+// once the output ends.
+type execReader struct {
+	name    string
+	cmd     *exec.Cmd
+	out     io.Reader
+	stderr  *bytes.Buffer
+	waited  bool
+	waitErr error
+}
+
+func (e *execReader) Read(p []byte) (int, error) {
+	if e.waited {
+		if e.waitErr != nil {
+			return 0, e.waitErr
+		}
+		return 0, io.EOF
+	}
+
+	n, err := e.out.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+
+	e.waited = true
+	if err := e.cmd.Wait(); err != nil {
+		msg := strings.TrimSpace(e.stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+
+		e.waitErr = fmt.Errorf("%s failed: %s", e.name, msg)
+		return n, e.waitErr
+	}
+
+	return n, io.EOF
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+// imageTarball returns a meta tarball with a metadata.yaml and some
+// padding, so compressed tarballs span several blocks.
+func imageTarball(t *testing.T) []byte {
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"metadata.yaml", []byte("architecture: x86_64\ncreation_date: 1508328000\n")},
+		{"templates/hostname.tpl", randomBytes(t, 100000)},
+	}
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestCheckImageFileXZ(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz is not installed")
+	}
+
+	cmd := exec.Command("xz", "-c")
+	cmd.Stdin = bytes.NewReader(imageTarball(t))
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The end of the data holds the check of the block, the index and the
+	// stream footer, so the tarball itself stays readable.
+	badCRC := append([]byte{}, compressed...)
+	badCRC[len(badCRC)-24] ^= 1
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"intact", compressed, true},
+		{"bad checksum", badCRC, false},
+		{"truncated", compressed[:len(compressed)-8], false},
+	}
+
+	for _, test := range tests {
+		err := CheckImageFile(bytes.NewReader(test.data), true)
+		if test.ok && err != nil {
+			t.Errorf("%s: CheckImageFile failed: %s", test.name, err)
+		}
+
+		if !test.ok && err == nil {
+			t.Errorf("%s: CheckImageFile succeeded", test.name)
+		}
+	}
+
+	metadata, err := ReadImageMetadata(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("ReadImageMetadata failed: %s", err)
+	}
+
+	if metadata.Architecture != "x86_64" {
+		t.Errorf("Expected architecture x86_64, got %s", metadata.Architecture)
+	}
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// nameKeyLabel separates the keys used for names from other uses of the
+// same secret.
+var nameKeyLabel = []byte("limbo object names")
+
+// IndexEntry maps the logical name of an object to the opaque name it is
+// stored under.
+type IndexEntry struct {
+	Name    string    `json:"name"`
+	Object  string    `json:"object"`
+	Created time.Time `json:"created"`
+}
+
+// ObfuscateName returns the opaque name an object with the given logical
+// name is stored under. It is an HMAC of the name, keyed with a key derived
+// from the secret the data is encrypted with.
+func ObfuscateName(name string, opts CryptOpts) (string, error) {
+	key, err := opts.nameKey()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// nameKey derives the key for ObfuscateName from the secret Encrypt would
+// use. Recipients' public keys and the IDs of key manager keys are not
+// secret, so names obfuscated with them only hide them from those who do
+// not know the keys.
+func (o CryptOpts) nameKey() ([]byte, error) {
+	switch {
+	case o.Mode == CryptModeOpenPGP && len(o.PGPRecipients) > 0:
+		h := sha256.New()
+		h.Write(nameKeyLabel)
+		for _, e := range o.PGPRecipients {
+			h.Write(e.PrimaryKey.Fingerprint[:])
+		}
+		return h.Sum(nil), nil
+	case o.Mode != CryptModeOpenPGP && len(o.Recipients) > 0:
+		h := sha256.New()
+		h.Write(nameKeyLabel)
+		for _, r := range o.Recipients {
+			h.Write(r[:])
+		}
+		return h.Sum(nil), nil
+	case o.Mode != CryptModeOpenPGP && o.KeyManager != nil:
+		h := sha256.New()
+		h.Write(nameKeyLabel)
+		h.Write([]byte(o.KeyManager.Name()))
+		h.Write([]byte(o.KeyManager.KeyID()))
+		return h.Sum(nil), nil
+	case o.Mode != CryptModeOpenPGP && o.Key != nil:
+		mac := hmac.New(sha256.New, o.Key[:])
+		mac.Write(nameKeyLabel)
+		return mac.Sum(nil), nil
+	}
+
+	pass, err := o.passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(pass), nameKeyLabel, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to derive name key: %s", err)
+	}
+
+	return key, nil
+}
+
+// canDeriveNameKey determines if the name key can be derived on the side
+// which decrypts, which holds private keys rather than recipients.
+func (o CryptOpts) canDeriveNameKey() bool {
+	if o.Mode == CryptModeOpenPGP {
+		return len(o.PGPKeyring) == 0
+	}
+
+	if o.KeyManager != nil {
+		return o.KeyManager.KeyID() != ""
+	}
+
+	return len(o.Identities) == 0
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Encryption modes.
+const (
+	CryptModeLimbo   = "limbo"
+	CryptModeOpenPGP = "openpgp"
+)
+
+// ParsePGPKeyRing reads an armored or binary OpenPGP key ring, as exported
+// by gpg --export or gpg --export-secret-keys.
+func ParsePGPKeyRing(data []byte) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read OpenPGP keys: %s", err)
+	}
+
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("No OpenPGP keys found")
+	}
+
+	return keyring, nil
+}
+
+// pgpEncrypt encrypts src as an OpenPGP message which can be decrypted with
+// gpg. The message is encrypted to the OpenPGP recipients if there are any,
+// and with the passphrase otherwise.
+func pgpEncrypt(dst io.Writer, src io.Reader, opts CryptOpts) error {
+	if opts.Key != nil || len(opts.Recipients) > 0 {
+		return fmt.Errorf("Key files are not supported in OpenPGP mode")
+	}
+
+	if opts.KeyManager != nil {
+		return fmt.Errorf("Key managers are not supported in OpenPGP mode")
+	}
+
+	var plain io.WriteCloser
+	var err error
+	hints := &openpgp.FileHints{
+		IsBinary: true,
+	}
+
+	if len(opts.PGPRecipients) > 0 {
+		plain, err = openpgp.Encrypt(dst, opts.PGPRecipients, nil, hints, nil)
+	} else {
+		var pass string
+		if pass, err = opts.passphrase(); err != nil {
+			return err
+		}
+
+		plain, err = openpgp.SymmetricallyEncrypt(dst, []byte(pass), hints, nil)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt data: %s", err)
+	}
+
+	if _, err := io.Copy(plain, src); err != nil {
+		return fmt.Errorf("Unable to encrypt data: %s", err)
+	}
+
+	if err := plain.Close(); err != nil {
+		return fmt.Errorf("Unable to encrypt data: %s", err)
+	}
+
+	return nil
+}
+
+// pgpDecrypt decrypts an OpenPGP message with the OpenPGP key ring or the
+// passphrase. The passphrase is also used to unlock protected private keys.
+func pgpDecrypt(dst io.Writer, src io.Reader, opts CryptOpts) error {
+	prompted := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		// The prompt is called again as long as decryption fails.
+		if prompted {
+			return nil, fmt.Errorf("wrong passphrase or key")
+		}
+		prompted = true
+
+		pass, err := opts.passphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			k.PrivateKey.Decrypt([]byte(pass))
+		}
+
+		if symmetric {
+			return []byte(pass), nil
+		}
+
+		return nil, nil
+	}
+
+	message, err := pgpCheckMessage(src)
+	if err != nil {
+		return err
+	}
+
+	md, err := openpgp.ReadMessage(message, opts.PGPKeyring, prompt, &packet.Config{})
+	if err != nil {
+		return fmt.Errorf("Unable to decrypt data: %s", err)
+	}
+
+	if !md.IsEncrypted {
+		return fmt.Errorf("Unable to decrypt data: the OpenPGP message is not encrypted")
+	}
+
+	// The integrity of the message is only checked once it was read
+	// completely, so a failure surfaces as a read error.
+	if _, err := io.Copy(dst, md.UnverifiedBody); err != nil {
+		return fmt.Errorf("Unable to decrypt data: %s", err)
+	}
+
+	if md.IsSigned && md.SignatureError != nil {
+		return fmt.Errorf("Unable to decrypt data: invalid signature: %s", md.SignatureError)
+	}
+
+	return nil
+}
+
+// pgpCheckMessage reads the packets of an OpenPGP message up to its
+// encrypted data. Messages which are not encrypted, and encrypted data
+// without an integrity check (MDC), which could be changed undetected, are
+// refused. The returned reader yields the complete message.
+func pgpCheckMessage(src io.Reader) (io.Reader, error) {
+	var head bytes.Buffer
+	r := io.TeeReader(src, &head)
+	for {
+		p, err := packet.Read(r)
+		if _, ok := err.(pgperrors.UnknownPacketTypeError); ok {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decrypt data: %s", err)
+		}
+
+		switch p := p.(type) {
+		case *packet.EncryptedKey, *packet.SymmetricKeyEncrypted:
+		case *packet.SymmetricallyEncrypted:
+			if !p.MDC {
+				return nil, fmt.Errorf("Unable to decrypt data: the OpenPGP message has no integrity check")
+			}
+
+			return io.MultiReader(&head, src), nil
+		default:
+			return nil, fmt.Errorf("Unable to decrypt data: the OpenPGP message is not encrypted")
+		}
+	}
+}
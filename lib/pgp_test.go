@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// pgpLiteral returns plain as an OpenPGP literal data packet.
+func pgpLiteral(t *testing.T, plain []byte) []byte {
+	var b bytes.Buffer
+	w, err := packet.SerializeLiteral(nopWriteCloser{&b}, true, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+// pgpEncryptWithoutMDC encrypts plain with a passphrase as a symmetrically
+// encrypted data packet without an integrity check, which older OpenPGP
+// implementations produced.
+func pgpEncryptWithoutMDC(t *testing.T, plain []byte, pass string) []byte {
+	var b bytes.Buffer
+	config := &packet.Config{DefaultCipher: packet.CipherAES128}
+	key, err := packet.SerializeSymmetricKeyEncrypted(&b, []byte(pass), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, prefix := packet.NewOCFBEncrypter(block, randomBytes(t, block.BlockSize()), packet.OCFBResync)
+	literal := pgpLiteral(t, plain)
+	body := make([]byte, len(literal))
+	stream.XORKeyStream(body, literal)
+
+	// A new format header of packet type 9 with a five-octet length.
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(prefix)+len(body)))
+	b.Write([]byte{0xc0 | 9, 0xff})
+	b.Write(length)
+	b.Write(prefix)
+	b.Write(body)
+
+	return b.Bytes()
+}
+
+func TestPGPEncryptDecrypt(t *testing.T) {
+	opts := CryptOpts{Pass: "secret", Mode: CryptModeOpenPGP}
+	plain := randomBytes(t, 100000)
+
+	got, err := decryptBytes(encryptBytes(t, plain, opts), opts)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %s", err)
+	}
+
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Decrypted data differs from the original")
+	}
+}
+
+func TestPGPDecryptRefused(t *testing.T) {
+	const pass = "secret"
+	plain := []byte("chosen plaintext")
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not encrypted", pgpLiteral(t, plain)},
+		{"no integrity check", pgpEncryptWithoutMDC(t, plain, pass)},
+	}
+
+	for _, test := range tests {
+		got, err := decryptBytes(test.data, CryptOpts{Pass: pass, Mode: CryptModeOpenPGP})
+		if err == nil {
+			t.Errorf("%s: Decrypt succeeded", test.name)
+		}
+
+		if bytes.Contains(got, plain) {
+			t.Errorf("%s: Decrypt wrote the data", test.name)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy decides which exports of a source are kept. The keep
+// rules work like those of other backup tools: an export is kept if any
+// rule selects it. The daily, weekly and monthly rules keep the newest
+// export of each of the last n days, weeks or months which have one.
+type RetentionPolicy struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+
+	// KeepWithin keeps all exports created within this duration before
+	// the newest export of a source, so nothing is lost if exports stop.
+	KeepWithin time.Duration
+
+	// MaxSize is the total size in bytes of the kept exports. The oldest
+	// exports are removed until it is met. 0 means no limit.
+	MaxSize int64
+}
+
+// HasKeepRules determines if the policy has any keep rules. Without them,
+// every export is kept unless MaxSize removes it.
+func (p RetentionPolicy) HasKeepRules() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 ||
+		p.KeepMonthly > 0 || p.KeepWithin > 0
+}
+
+// RetentionDecision is the outcome of a policy for an export.
+type RetentionDecision struct {
+	Image SwiftImageSummary
+
+	// Source is the LXD container or image the export was taken from.
+	Source string
+
+	Keep bool
+
+	// Reasons lists why the export is kept or removed.
+	Reasons []string
+}
+
+// ApplyRetention applies a policy to the exports of each source. The
+// newest export of a source and the exports in protected are never removed.
+// protected maps their names to the reason. If tmpl is set, sources are
+// taken from the object names it built. Decisions are returned by source,
+// newest first.
+func ApplyRetention(images []SwiftImageSummary, policy RetentionPolicy, protected map[string]string, tmpl *NameTemplate) []RetentionDecision {
+	var decisions []RetentionDecision
+	for _, image := range images {
+		decisions = append(decisions, RetentionDecision{
+			Image:  image,
+			Source: ImageSource(image, tmpl),
+		})
+	}
+
+	sort.SliceStable(decisions, func(i, j int) bool {
+		a, b := decisions[i], decisions[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+
+		if !a.Image.Created.Equal(b.Image.Created) {
+			return a.Image.Created.After(b.Image.Created)
+		}
+
+		return a.Image.Name > b.Image.Name
+	})
+
+	buckets := []struct {
+		name  string
+		count int
+		key   func(time.Time) string
+	}{
+		{"daily", policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", policy.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{"monthly", policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	var newest time.Time
+	var n int
+	remaining := make([]int, len(buckets))
+	last := make([]string, len(buckets))
+	for i := range decisions {
+		d := &decisions[i]
+
+		// Start over for each source.
+		if i == 0 || d.Source != decisions[i-1].Source {
+			newest = d.Image.Created
+			n = 0
+			for b := range buckets {
+				remaining[b] = buckets[b].count
+				last[b] = ""
+			}
+
+			d.Reasons = append(d.Reasons, "newest")
+		}
+
+		if reason, ok := protected[d.Image.Name]; ok {
+			d.Reasons = append(d.Reasons, reason)
+		}
+
+		if n < policy.KeepLast {
+			d.Reasons = append(d.Reasons, "last")
+		}
+		n++
+
+		if policy.KeepWithin > 0 && !d.Image.Created.Before(newest.Add(-policy.KeepWithin)) {
+			d.Reasons = append(d.Reasons, "within")
+		}
+
+		created := d.Image.Created.Local()
+		for b := range buckets {
+			key := buckets[b].key(created)
+			if key == last[b] {
+				continue
+			}
+
+			last[b] = key
+			if remaining[b] > 0 {
+				remaining[b]--
+				d.Reasons = append(d.Reasons, buckets[b].name)
+			}
+		}
+
+		d.Keep = len(d.Reasons) > 0 || !policy.HasKeepRules()
+		if !d.Keep {
+			d.Reasons = append(d.Reasons, "no rule")
+		}
+	}
+
+	if policy.MaxSize > 0 {
+		applySizeBudget(decisions, policy.MaxSize, protected)
+	}
+
+	return decisions
+}
+
+// applySizeBudget removes the oldest kept exports until the kept exports
+// fit into maxSize. The newest export of each source and protected exports
+// are not removed, so the budget may not be met.
+func applySizeBudget(decisions []RetentionDecision, maxSize int64, protected map[string]string) {
+	var total int64
+	var candidates []*RetentionDecision
+	for i := range decisions {
+		d := &decisions[i]
+		if !d.Keep {
+			continue
+		}
+
+		total += d.Image.Size
+		if _, ok := protected[d.Image.Name]; !ok && !hasReason(d.Reasons, "newest") {
+			candidates = append(candidates, d)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Image.Created.Before(candidates[j].Image.Created)
+	})
+
+	for _, d := range candidates {
+		if total <= maxSize {
+			break
+		}
+
+		d.Keep = false
+		d.Reasons = []string{"size budget"}
+		total -= d.Image.Size
+	}
+}
+
+func hasReason(reasons []string, reason string) bool {
+	for _, r := range reasons {
+		if r == reason {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImageSource returns the LXD container or image an export was taken
+// from. It is taken from the name if tmpl built it with a {name}
+// placeholder, then from the manifest, or from the name without its
+// @timestamp suffix if the manifest is missing or encrypted. The logical
+// name of an obfuscated image is used if it is known.
+func ImageSource(image SwiftImageSummary, tmpl *NameTemplate) string {
+	name := image.DisplayName()
+	if tmpl != nil {
+		if v, ok := tmpl.Match(name); ok && v.Source() != "" {
+			return v.Source()
+		}
+	}
+
+	if m := image.Manifest; m != nil && m.Source.Name != "" {
+		if m.Source.Remote != "" {
+			return m.Source.Remote + ":" + m.Source.Name
+		}
+
+		return m.Source.Name
+	}
+
+	return strings.SplitN(name, "@", 2)[0]
+}
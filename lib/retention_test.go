@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+// retentionImages builds exports from "source@date" names, dated in local
+// time like the retention buckets.
+func retentionImages(t *testing.T, size int64, names ...string) []SwiftImageSummary {
+	var images []SwiftImageSummary
+	for _, name := range names {
+		v, err := time.ParseInLocation("2006-01-02T15:04:05", name[len(name)-19:], time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		images = append(images, SwiftImageSummary{
+			Name:    name,
+			Created: v,
+			Size:    size,
+		})
+	}
+
+	return images
+}
+
+// checkRetention compares the decisions with the names expected to be kept.
+func checkRetention(t *testing.T, name string, decisions []RetentionDecision, kept []string) {
+	expected := map[string]bool{}
+	for _, k := range kept {
+		expected[k] = true
+	}
+
+	for _, d := range decisions {
+		if d.Keep != expected[d.Image.Name] {
+			t.Errorf("%s: expected keep=%t for %s, got keep=%t (%v)", name, expected[d.Image.Name], d.Image.Name, d.Keep, d.Reasons)
+		}
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RetentionPolicy
+		images    []string
+		protected map[string]string
+		kept      []string
+	}{
+		{
+			name:   "no keep rules",
+			policy: RetentionPolicy{},
+			images: []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00"},
+			kept:   []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00"},
+		},
+		{
+			name:   "last",
+			policy: RetentionPolicy{KeepLast: 2},
+			images: []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00", "a@2026-10-16T12:00:00", "a@2026-10-15T12:00:00"},
+			kept:   []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00"},
+		},
+		{
+			name:   "daily across midnight",
+			policy: RetentionPolicy{KeepDaily: 2},
+			images: []string{"a@2026-10-18T12:00:00", "a@2026-10-18T00:00:00", "a@2026-10-17T23:59:59", "a@2026-10-16T23:59:59"},
+			kept:   []string{"a@2026-10-18T12:00:00", "a@2026-10-17T23:59:59"},
+		},
+		{
+			name:   "daily skips days without exports",
+			policy: RetentionPolicy{KeepDaily: 2},
+			images: []string{"a@2026-10-18T12:00:00", "a@2026-10-10T12:00:00", "a@2026-10-01T12:00:00"},
+			kept:   []string{"a@2026-10-18T12:00:00", "a@2026-10-10T12:00:00"},
+		},
+		{
+			name:   "weekly starts on monday",
+			policy: RetentionPolicy{KeepWeekly: 2},
+			images: []string{"a@2026-10-19T10:00:00", "a@2026-10-18T22:00:00", "a@2026-10-18T08:00:00", "a@2026-10-12T00:00:00"},
+			kept:   []string{"a@2026-10-19T10:00:00", "a@2026-10-18T22:00:00"},
+		},
+		{
+			name:   "weekly across the year",
+			policy: RetentionPolicy{KeepWeekly: 2},
+			images: []string{"a@2027-01-03T12:00:00", "a@2027-01-01T12:00:00", "a@2026-12-31T12:00:00", "a@2026-12-27T12:00:00"},
+			kept:   []string{"a@2027-01-03T12:00:00", "a@2026-12-27T12:00:00"},
+		},
+		{
+			name:   "monthly",
+			policy: RetentionPolicy{KeepMonthly: 2},
+			images: []string{"a@2026-10-01T00:00:00", "a@2026-09-30T23:59:59", "a@2026-09-15T12:00:00", "a@2026-08-31T12:00:00"},
+			kept:   []string{"a@2026-10-01T00:00:00", "a@2026-09-30T23:59:59"},
+		},
+		{
+			name:   "rules combined",
+			policy: RetentionPolicy{KeepLast: 1, KeepDaily: 2, KeepMonthly: 2},
+			images: []string{"a@2026-10-18T12:00:00", "a@2026-10-18T06:00:00", "a@2026-10-17T12:00:00", "a@2026-10-16T12:00:00", "a@2026-09-20T12:00:00", "a@2026-09-10T12:00:00"},
+			kept:   []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00", "a@2026-09-20T12:00:00"},
+		},
+		{
+			name:   "within is relative to the newest export",
+			policy: RetentionPolicy{KeepWithin: 24 * time.Hour},
+			images: []string{"a@2026-10-10T12:00:00", "a@2026-10-09T12:00:00", "a@2026-10-09T11:59:59"},
+			kept:   []string{"a@2026-10-10T12:00:00", "a@2026-10-09T12:00:00"},
+		},
+		{
+			name:   "newest of each source",
+			policy: RetentionPolicy{KeepLast: 1},
+			images: []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00", "b@2026-10-01T12:00:00", "b@2026-09-01T12:00:00"},
+			kept:   []string{"a@2026-10-18T12:00:00", "b@2026-10-01T12:00:00"},
+		},
+		{
+			name:      "protected",
+			policy:    RetentionPolicy{KeepLast: 1},
+			images:    []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00", "a@2026-10-16T12:00:00"},
+			protected: map[string]string{"a@2026-10-16T12:00:00": "do-not-delete"},
+			kept:      []string{"a@2026-10-18T12:00:00", "a@2026-10-16T12:00:00"},
+		},
+	}
+
+	for _, test := range tests {
+		images := retentionImages(t, 10, test.images...)
+		decisions := ApplyRetention(images, test.policy, test.protected, nil)
+		if len(decisions) != len(images) {
+			t.Fatalf("%s: expected %d decisions, got %d", test.name, len(images), len(decisions))
+		}
+
+		checkRetention(t, test.name, decisions, test.kept)
+	}
+}
+
+func TestApplyRetentionOrder(t *testing.T) {
+	images := retentionImages(t, 10, "b@2026-10-01T12:00:00", "a@2026-10-17T12:00:00", "b@2026-10-02T12:00:00", "a@2026-10-18T12:00:00")
+	decisions := ApplyRetention(images, RetentionPolicy{}, nil, nil)
+
+	expected := []string{"a@2026-10-18T12:00:00", "a@2026-10-17T12:00:00", "b@2026-10-02T12:00:00", "b@2026-10-01T12:00:00"}
+	for i, d := range decisions {
+		if d.Image.Name != expected[i] {
+			t.Errorf("Expected %s at position %d, got %s", expected[i], i, d.Image.Name)
+		}
+	}
+
+	if decisions[0].Source != "a" || decisions[2].Source != "b" {
+		t.Errorf("Unexpected sources: %s, %s", decisions[0].Source, decisions[2].Source)
+	}
+
+	for _, i := range []int{0, 2} {
+		if !hasReason(decisions[i].Reasons, "newest") {
+			t.Errorf("%s is not marked as newest: %v", decisions[i].Image.Name, decisions[i].Reasons)
+		}
+	}
+}
+
+func TestApplyRetentionSizeBudget(t *testing.T) {
+	images := []string{
+		"a@2026-10-18T12:00:00",
+		"a@2026-10-16T12:00:00",
+		"a@2026-10-14T12:00:00",
+		"b@2026-10-17T12:00:00",
+		"b@2026-10-15T12:00:00",
+	}
+
+	tests := []struct {
+		name      string
+		policy    RetentionPolicy
+		protected map[string]string
+		kept      []string
+	}{
+		{
+			name:   "within budget",
+			policy: RetentionPolicy{MaxSize: 50},
+			kept:   images,
+		},
+		{
+			name:   "oldest removed first",
+			policy: RetentionPolicy{MaxSize: 35},
+			kept:   []string{"a@2026-10-18T12:00:00", "a@2026-10-16T12:00:00", "b@2026-10-17T12:00:00"},
+		},
+		{
+			name:   "newest of each source kept over budget",
+			policy: RetentionPolicy{MaxSize: 5},
+			kept:   []string{"a@2026-10-18T12:00:00", "b@2026-10-17T12:00:00"},
+		},
+		{
+			name:      "protected kept over budget",
+			policy:    RetentionPolicy{MaxSize: 5},
+			protected: map[string]string{"a@2026-10-14T12:00:00": "do-not-delete"},
+			kept:      []string{"a@2026-10-18T12:00:00", "a@2026-10-14T12:00:00", "b@2026-10-17T12:00:00"},
+		},
+		{
+			name:   "exports removed by rules do not count",
+			policy: RetentionPolicy{KeepLast: 2, MaxSize: 30},
+			kept:   []string{"a@2026-10-18T12:00:00", "a@2026-10-16T12:00:00", "b@2026-10-17T12:00:00"},
+		},
+	}
+
+	for _, test := range tests {
+		decisions := ApplyRetention(retentionImages(t, 10, images...), test.policy, test.protected, nil)
+		checkRetention(t, test.name, decisions, test.kept)
+
+		for _, d := range decisions {
+			if !d.Keep && hasReason(d.Reasons, "newest") {
+				t.Errorf("%s: the newest export %s was removed", test.name, d.Image.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sirupsen/logrus"
+)
+
+// RetryOpts describes how failed Swift and LXD requests are retried.
+type RetryOpts struct {
+	// Retries is the number of times a failed request is retried.
+	// A value of 0 disables retrying.
+	Retries int
+
+	// MaxWait is the longest amount of time to wait between two attempts.
+	MaxWait time.Duration
+
+	// Logger receives a debug message for every retried attempt.
+	Logger *logrus.Logger
+}
+
+// Do runs f until it succeeds, fails with an error which is not an
+// ErrTransient, or the number of retries has been exhausted. The
+// description is only used for logging.
+func (r RetryOpts) Do(description string, f func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = f(); err == nil {
+			return nil
+		}
+
+		if attempt >= r.Retries || !IsTransient(err) {
+			return err
+		}
+
+		wait := r.backoff(attempt)
+		r.debugf("Retrying %s in %s (attempt %d/%d): %s",
+			description, wait, attempt+1, r.Retries, err)
+		time.Sleep(wait)
+	}
+}
+
+// ErrTransient is an error which might not occur again if the failed
+// operation is repeated, such as a lost connection.
+type ErrTransient struct {
+	Err error
+}
+
+func (e ErrTransient) Error() string {
+	return e.Err.Error()
+}
+
+// IsTransient reports whether err was marked as transient.
+func IsTransient(err error) bool {
+	_, ok := err.(ErrTransient)
+	return ok
+}
+
+// transientError returns err as an ErrTransient if its cause is a network
+// error, a temporary server-side problem or already marked as transient.
+// Otherwise err is returned as-is.
+func transientError(cause, err error) error {
+	switch c := cause.(type) {
+	case ErrTransient,
+		gophercloud.ErrDefault408,
+		gophercloud.ErrDefault429,
+		gophercloud.ErrDefault500,
+		gophercloud.ErrDefault503:
+		return ErrTransient{err}
+	case gophercloud.ErrUnexpectedResponseCode:
+		if c.Actual == http.StatusBadGateway || c.Actual == http.StatusGatewayTimeout {
+			return ErrTransient{err}
+		}
+	}
+
+	var opErr *net.OpError
+	var netErr net.Error
+	if errors.As(cause, &opErr) ||
+		(errors.As(cause, &netErr) && netErr.Timeout()) ||
+		errors.Is(cause, io.ErrUnexpectedEOF) ||
+		errors.Is(cause, syscall.ECONNRESET) ||
+		errors.Is(cause, syscall.EPIPE) {
+		return ErrTransient{err}
+	}
+
+	return err
+}
+
+// backoff returns an exponentially increasing, jittered wait time for the
+// given attempt. The result never exceeds MaxWait.
+func (r RetryOpts) backoff(attempt int) time.Duration {
+	wait := time.Second << uint(attempt)
+	if wait <= 0 || (r.MaxWait > 0 && wait > r.MaxWait) {
+		wait = r.MaxWait
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (r RetryOpts) debugf(format string, args ...interface{}) {
+	if r.Logger != nil {
+		r.Logger.Debugf(format, args...)
+	}
+}
+
+// retryTransport is an http.RoundTripper which retries requests that failed
+// because of a network error or a temporary server-side problem.
+type retryTransport struct {
+	opts      RetryOpts
+	methods   map[string]bool
+	transport http.RoundTripper
+}
+
+// newRetryTransport wraps transport so that requests using one of the given
+// methods are retried according to opts.
+func newRetryTransport(transport http.RoundTripper, opts RetryOpts, methods ...string) http.RoundTripper {
+	if opts.Retries <= 0 {
+		return transport
+	}
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	t := &retryTransport{
+		opts:      opts,
+		methods:   map[string]bool{},
+		transport: transport,
+	}
+
+	for _, m := range methods {
+		t.methods[m] = true
+	}
+
+	return t
+}
+
+// replayableBody is a request body which can be sent again, such as a
+// spooled segment. http.NewRequest only sets GetBody for bodies held in
+// memory.
+type replayableBody interface {
+	io.ReadCloser
+	Replay() (io.ReadCloser, error)
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	getBody := req.GetBody
+	if b, ok := req.Body.(replayableBody); ok && getBody == nil {
+		getBody = b.Replay
+	}
+
+	// Only idempotent requests whose body can be sent again are retried.
+	if !t.methods[req.Method] || (req.Body != nil && getBody == nil) {
+		return t.transport.RoundTrip(req)
+	}
+
+	description := fmt.Sprintf("%s %s", req.Method, req.URL.Path)
+	for attempt := 0; ; attempt++ {
+		// A RoundTripper must not change the request it was given, so
+		// each further attempt sends a copy with a fresh body.
+		attemptReq := req
+		if attempt > 0 && getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.transport.RoundTrip(attemptReq)
+		if attempt >= t.opts.Retries || !retryable(resp, err) {
+			return resp, err
+		}
+
+		wait := t.opts.backoff(attempt)
+		reason := fmt.Sprintf("%v", err)
+		if resp != nil {
+			reason = resp.Status
+			if after, ok := retryAfter(resp); ok {
+				wait = after
+				if t.opts.MaxWait > 0 && wait > t.opts.MaxWait {
+					wait = t.opts.MaxWait
+				}
+			}
+			resp.Body.Close()
+		}
+
+		t.opts.debugf("Retrying %s in %s (attempt %d/%d): %s",
+			description, wait, attempt+1, t.opts.Retries, reason)
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryable determines if a request should be attempted again.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// retryAfter parses the Retry-After header of 429 and 503 responses.
+// The header can either be a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		wait := time.Until(t)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
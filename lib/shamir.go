@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// KeyShare is one share of a key which was split with Shamir's secret
+// sharing. Any Threshold shares of the same key rebuild it, while fewer
+// shares reveal nothing about it.
+type KeyShare struct {
+	// KeyType is the PEM type of the key which was split.
+	KeyType string
+
+	// KeyID identifies the key, so shares of different keys are not mixed
+	// up. It is the start of the SHA-256 hash of the key.
+	KeyID string
+
+	// Index is the x coordinate of the share, starting at 1.
+	Index byte
+
+	// Shares is the number of shares the key was split into.
+	Shares byte
+
+	// Threshold is the number of shares needed to rebuild the key.
+	Threshold byte
+
+	// Data holds one byte of the share for each byte of the key.
+	Data []byte
+}
+
+// SplitKey splits a key into n shares, any k of which rebuild it.
+func SplitKey(keyType string, key []byte, n, k int) ([]KeyShare, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("Invalid number of shares: %d of %d. 2 <= threshold <= shares <= 255 is required", k, n)
+	}
+
+	shares := make([]KeyShare, n)
+	for i := range shares {
+		shares[i] = KeyShare{
+			KeyType:   keyType,
+			KeyID:     keyID(key),
+			Index:     byte(i + 1),
+			Shares:    byte(n),
+			Threshold: byte(k),
+			Data:      make([]byte, len(key)),
+		}
+	}
+
+	// Each byte of the key is the constant term of a random polynomial of
+	// degree k-1, which is evaluated at the index of every share.
+	coefficients := make([]byte, k)
+	for b := range key {
+		coefficients[0] = key[b]
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("Unable to generate shares: %s", err)
+		}
+
+		for i := range shares {
+			shares[i].Data[b] = gfEval(coefficients, shares[i].Index)
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineKeyShares rebuilds a key from at least Threshold of its shares. It
+// returns the PEM type of the key and the key.
+func CombineKeyShares(shares []KeyShare) (string, []byte, error) {
+	if len(shares) == 0 {
+		return "", nil, fmt.Errorf("No key shares given")
+	}
+
+	first := shares[0]
+	seen := map[byte]bool{}
+	for _, s := range shares {
+		if s.KeyID != first.KeyID || s.KeyType != first.KeyType || s.Threshold != first.Threshold || len(s.Data) != len(first.Data) {
+			return "", nil, fmt.Errorf("Key shares belong to different keys")
+		}
+
+		if s.Index == 0 || seen[s.Index] {
+			return "", nil, fmt.Errorf("Key share %d is given more than once", s.Index)
+		}
+		seen[s.Index] = true
+	}
+
+	if len(shares) < int(first.Threshold) {
+		return "", nil, fmt.Errorf("%d key shares are required, got %d", first.Threshold, len(shares))
+	}
+
+	// Interpolate the polynomials at x = 0.
+	shares = shares[:first.Threshold]
+	key := make([]byte, len(first.Data))
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(sj.Index, sj.Index^si.Index))
+			}
+		}
+
+		for b := range key {
+			key[b] ^= gfMul(si.Data[b], basis)
+		}
+	}
+
+	if keyID(key) != first.KeyID {
+		return "", nil, fmt.Errorf("Key shares do not rebuild the key. At least one share is damaged")
+	}
+
+	return first.KeyType, key, nil
+}
+
+// Marshal encodes a share as a PEM block. The details of the share are kept
+// in readable headers, so printed shares can be told apart.
+func (s KeyShare) Marshal() []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type: PEMKeyShare,
+		Headers: map[string]string{
+			"Key-Type":  s.KeyType,
+			"Key-ID":    s.KeyID,
+			"Share":     fmt.Sprintf("%d of %d", s.Index, s.Shares),
+			"Threshold": strconv.Itoa(int(s.Threshold)),
+		},
+		Bytes: s.Data,
+	})
+}
+
+// ParseKeyShare reads a share encoded by Marshal.
+func ParseKeyShare(data []byte) (*KeyShare, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil || block.Type != PEMKeyShare {
+		return nil, fmt.Errorf("No %s found", PEMKeyShare)
+	}
+
+	s := &KeyShare{
+		KeyType: block.Headers["Key-Type"],
+		KeyID:   block.Headers["Key-ID"],
+		Data:    block.Bytes,
+	}
+
+	var index, shares, threshold int
+	if _, err := fmt.Sscanf(block.Headers["Share"], "%d of %d", &index, &shares); err != nil {
+		return nil, fmt.Errorf("Invalid %s: malformed Share header", PEMKeyShare)
+	}
+
+	threshold, err := strconv.Atoi(block.Headers["Threshold"])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: malformed Threshold header", PEMKeyShare)
+	}
+
+	if index < 1 || index > shares || threshold < 2 || threshold > shares || shares > 255 {
+		return nil, fmt.Errorf("Invalid %s: share %d of %d with threshold %d", PEMKeyShare, index, shares, threshold)
+	}
+
+	s.Index = byte(index)
+	s.Shares = byte(shares)
+	s.Threshold = byte(threshold)
+
+	return s, nil
+}
+
+// keyID returns the ID shares of a key carry.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// gfEval evaluates a polynomial over GF(2^8) at x.
+func gfEval(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+
+	return y
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial. It does not branch
+// on its arguments.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		carry := a >> 7
+		a = a<<1 ^ 0x1b&-carry
+		b >>= 1
+	}
+
+	return p
+}
+
+// gfDiv divides in GF(2^8). b must not be 0.
+func gfDiv(a, b byte) byte {
+	// b^254 is the inverse of b.
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, b)
+	}
+
+	return gfMul(a, inv)
+}
@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+)
+
+// subsets returns every subset of shares, in order of the shares.
+func subsets(shares []KeyShare) [][]KeyShare {
+	var result [][]KeyShare
+	for mask := 1; mask < 1<<uint(len(shares)); mask++ {
+		var subset []KeyShare
+		for i := range shares {
+			if mask&(1<<uint(i)) != 0 {
+				subset = append(subset, shares[i])
+			}
+		}
+		result = append(result, subset)
+	}
+
+	return result
+}
+
+func TestSplitCombineKey(t *testing.T) {
+	key := randomBytes(t, 32)
+
+	tests := []struct {
+		n, k int
+	}{
+		{2, 2},
+		{3, 2},
+		{3, 3},
+		{5, 3},
+		{6, 4},
+	}
+
+	for _, test := range tests {
+		shares, err := SplitKey(PEMSecretKey, key, test.n, test.k)
+		if err != nil {
+			t.Fatalf("%d of %d: SplitKey failed: %s", test.k, test.n, err)
+		}
+
+		if len(shares) != test.n {
+			t.Fatalf("%d of %d: expected %d shares, got %d", test.k, test.n, test.n, len(shares))
+		}
+
+		for _, subset := range subsets(shares) {
+			keyType, got, err := CombineKeyShares(subset)
+			if len(subset) < test.k {
+				if err == nil {
+					t.Errorf("%d of %d: CombineKeyShares succeeded with %d shares", test.k, test.n, len(subset))
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%d of %d: CombineKeyShares failed with %d shares: %s", test.k, test.n, len(subset), err)
+				continue
+			}
+
+			if keyType != PEMSecretKey || !bytes.Equal(got, key) {
+				t.Errorf("%d of %d: %d shares rebuilt the wrong key", test.k, test.n, len(subset))
+			}
+		}
+
+		// The shares are also rebuilt in any order.
+		reversed := make([]KeyShare, test.k)
+		for i := range reversed {
+			reversed[i] = shares[test.k-1-i]
+		}
+		if _, got, err := CombineKeyShares(reversed); err != nil || !bytes.Equal(got, key) {
+			t.Errorf("%d of %d: reversed shares did not rebuild the key: %v", test.k, test.n, err)
+		}
+	}
+}
+
+func TestSplitKeyInvalid(t *testing.T) {
+	key := randomBytes(t, 32)
+
+	tests := []struct {
+		n, k int
+	}{
+		{1, 1},
+		{3, 1},
+		{2, 3},
+		{256, 2},
+	}
+
+	for _, test := range tests {
+		if _, err := SplitKey(PEMSecretKey, key, test.n, test.k); err == nil {
+			t.Errorf("%d of %d: SplitKey succeeded", test.k, test.n)
+		}
+	}
+}
+
+func TestCombineKeySharesInvalid(t *testing.T) {
+	key := randomBytes(t, 32)
+	shares, err := SplitKey(PEMSecretKey, key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherShares, err := SplitKey(PEMSecretKey, randomBytes(t, 32), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Splitting the same key again gives shares with the same key ID which
+	// lie on other polynomials.
+	resplitShares, err := SplitKey(PEMSecretKey, key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	damaged := shares[1]
+	damaged.Data = append([]byte{}, damaged.Data...)
+	damaged.Data[0] ^= 1
+
+	otherType := shares[1]
+	otherType.KeyType = PEMPrivateKey
+
+	otherThreshold := shares[1]
+	otherThreshold.Threshold = 3
+
+	tests := []struct {
+		name   string
+		shares []KeyShare
+	}{
+		{"no shares", nil},
+		{"too few shares", shares[:1]},
+		{"shares of different keys", []KeyShare{shares[0], otherShares[1]}},
+		{"shares of different splits", []KeyShare{shares[0], resplitShares[1]}},
+		{"shares of different key types", []KeyShare{shares[0], otherType}},
+		{"shares with different thresholds", []KeyShare{shares[0], otherThreshold}},
+		{"share given twice", []KeyShare{shares[0], shares[0]}},
+		{"damaged share", []KeyShare{shares[0], damaged}},
+	}
+
+	for _, test := range tests {
+		if _, _, err := CombineKeyShares(test.shares); err == nil {
+			t.Errorf("%s: CombineKeyShares succeeded", test.name)
+		}
+	}
+}
+
+func TestKeyShareMarshal(t *testing.T) {
+	shares, err := SplitKey(PEMSecretKey, randomBytes(t, 32), 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range shares {
+		parsed, err := ParseKeyShare(s.Marshal())
+		if err != nil {
+			t.Fatalf("Share %d: ParseKeyShare failed: %s", s.Index, err)
+		}
+
+		if parsed.KeyType != s.KeyType || parsed.KeyID != s.KeyID || parsed.Index != s.Index ||
+			parsed.Shares != s.Shares || parsed.Threshold != s.Threshold || !bytes.Equal(parsed.Data, s.Data) {
+			t.Errorf("Share %d: parsed share differs from the original", s.Index)
+		}
+	}
+}
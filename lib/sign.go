@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ImageSignature is the statement which is signed for an exported image.
+// It lists every object of the image together with the size and SHA-256
+// hash of the data stored in Swift.
+type ImageSignature struct {
+	Image   string         `json:"image"`
+	Created time.Time      `json:"created"`
+	Objects []SignedObject `json:"objects"`
+}
+
+// SignedObject describes the stored data of a single object.
+type SignedObject struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// signatureFile is the format of a detached signature. The statement is
+// kept as raw bytes so it is verified exactly as it was signed.
+type signatureFile struct {
+	Statement []byte `json:"statement"`
+	Signature []byte `json:"signature"`
+}
+
+// Object returns the signed description of the object with the given name,
+// or nil if the object is not covered by the signature.
+func (s ImageSignature) Object(name string) *SignedObject {
+	for i := range s.Objects {
+		if s.Objects[i].Name == name {
+			return &s.Objects[i]
+		}
+	}
+
+	return nil
+}
+
+// SignImage returns a detached Ed25519 signature of an image.
+func SignImage(sig ImageSignature, key ed25519.PrivateKey) ([]byte, error) {
+	statement, err := json.Marshal(sig)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode signature statement: %s", err)
+	}
+
+	return json.Marshal(signatureFile{
+		Statement: statement,
+		Signature: ed25519.Sign(key, statement),
+	})
+}
+
+// VerifyImage checks a detached signature created by SignImage against a
+// list of trusted keys and returns the signed statement.
+func VerifyImage(data []byte, keys []ed25519.PublicKey) (*ImageSignature, error) {
+	var f signatureFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Signature is malformed: %s", err)
+	}
+
+	verified := false
+	for _, key := range keys {
+		if ed25519.Verify(key, f.Statement, f.Signature) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, fmt.Errorf("Signature is invalid or was not made by a trusted key")
+	}
+
+	var sig ImageSignature
+	if err := json.Unmarshal(f.Statement, &sig); err != nil {
+		return nil, fmt.Errorf("Signature statement is malformed: %s", err)
+	}
+
+	return &sig, nil
+}
+
+// verifyingReader checks that the data read from it matches a signed
+// object. A mismatch is reported instead of io.EOF, so consumers never see
+// the end of tampered data as a successful read.
+type verifyingReader struct {
+	r      io.Reader
+	object SignedObject
+	hash   hash.Hash
+	n      int64
+}
+
+// NewVerifyingReader returns a reader which fails at the end of the data if
+// it does not match the size and hash of object.
+func NewVerifyingReader(r io.Reader, object SignedObject) io.Reader {
+	return &verifyingReader{
+		r:      r,
+		object: object,
+		hash:   sha256.New(),
+	}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	v.n += int64(n)
+
+	if v.n > v.object.Size {
+		return n, fmt.Errorf("%s is larger than signed", v.object.Name)
+	}
+
+	if err == io.EOF {
+		sum, _ := hex.DecodeString(v.object.SHA256)
+		if v.n != v.object.Size || !bytes.Equal(v.hash.Sum(nil), sum) {
+			return n, fmt.Errorf("%s does not match its signature", v.object.Name)
+		}
+	}
+
+	return n, err
+}
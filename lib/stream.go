@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"io"
+	"sync"
+)
+
+// StreamWriter is an io.WriteCloser which hands everything written to it to
+// a consumer running in its own goroutine. It is used to connect the stages
+// of an import or export without storing any data locally.
+type StreamWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// NewStreamWriter starts consume in a new goroutine. consume reads from the
+// data written to the returned StreamWriter until it is closed.
+func NewStreamWriter(consume func(r io.Reader) error) *StreamWriter {
+	pr, pw := io.Pipe()
+	w := &StreamWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		err := consume(pr)
+
+		// Unblock any further writes if the consumer stopped early.
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.CloseWithError(io.ErrClosedPipe)
+		}
+
+		w.done <- err
+	}()
+
+	return w
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close signals the end of the data to the consumer and waits for it to
+// finish. The error of the consumer is returned.
+func (w *StreamWriter) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+// CloseWithError aborts the consumer with the given error and waits for it
+// to finish.
+func (w *StreamWriter) CloseWithError(err error) error {
+	w.pw.CloseWithError(err)
+	<-w.done
+	return err
+}
+
+// StreamReader is an io.ReadCloser which reads the data written by a
+// producer running in its own goroutine. The producer is only started on
+// the first read, so a StreamReader can be created long before it is used.
+type StreamReader struct {
+	pr      *io.PipeReader
+	pw      *io.PipeWriter
+	produce func(w io.Writer) error
+	once    sync.Once
+}
+
+// NewStreamReader returns a StreamReader for the data written by produce.
+func NewStreamReader(produce func(w io.Writer) error) *StreamReader {
+	pr, pw := io.Pipe()
+	return &StreamReader{
+		pr:      pr,
+		pw:      pw,
+		produce: produce,
+	}
+}
+
+func (r *StreamReader) Read(p []byte) (int, error) {
+	r.once.Do(func() {
+		go func() {
+			r.pw.CloseWithError(r.produce(r.pw))
+		}()
+	})
+
+	return r.pr.Read(p)
+}
+
+// Close stops reading. A running producer fails on its next write.
+func (r *StreamReader) Close() error {
+	return r.pr.Close()
+}
+
+// closeWithError closes a writer, passing err along if the writer supports
+// it.
+func closeWithError(w io.Closer, err error) error {
+	if err != nil {
+		if c, ok := w.(interface {
+			CloseWithError(error) error
+		}); ok {
+			return c.CloseWithError(err)
+		}
+	}
+
+	return w.Close()
+}
+
+// countingReader counts the bytes read from a reader and remembers any
+// error the reader returned other than io.EOF.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+
+	return n, err
+}
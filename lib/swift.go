@@ -1,18 +1,29 @@
 package lib
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/containers"
 	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/objects"
 	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/swauth"
+	"github.com/gophercloud/gophercloud/pagination"
+	"golang.org/x/crypto/ed25519"
 )
 
 type SwiftAuthOpts struct {
@@ -29,6 +40,7 @@ type SwiftAuthOpts struct {
 	CACert           string
 	Insecure         bool
 	Swauth           bool
+	Retry            RetryOpts
 }
 
 func GetSwiftClient(opts SwiftAuthOpts) (*gophercloud.ServiceClient, error) {
@@ -63,8 +75,11 @@ func GetSwiftClient(opts SwiftAuthOpts) (*gophercloud.ServiceClient, error) {
 
 	config.InsecureSkipVerify = opts.Insecure
 
+	// A POST replaces all metadata of an object, so replaying one whose
+	// response was lost could undo a concurrent update. It is not retried.
 	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: config}
-	client.HTTPClient.Transport = transport
+	client.HTTPClient.Transport = newRetryTransport(transport, opts.Retry,
+		"GET", "HEAD", "PUT", "DELETE", "COPY")
 
 	if opts.Swauth {
 		return swauth.NewObjectStorageV1(client, swauth.AuthOpts{
@@ -134,30 +149,236 @@ func SwiftCreateContainer(client *gophercloud.ServiceClient, name string, create
 	return nil
 }
 
+// SwiftSegmentSuffix is appended to the name of a storage container to form
+// the name of the container which holds the segments of uploaded objects.
+const SwiftSegmentSuffix = "_segments"
+
 type SwiftUploadOpts struct {
-	SourceName       string
 	StorageContainer string
 	ObjectName       string
+	SegmentSize      int64
+	Metadata         map[string]string
+
+	// TempDir is the directory the segment being uploaded is spooled to.
+	// If unset, the default directory for temporary files is used.
+	TempDir string
 }
 
 type SwiftUploadResults struct {
 	Headers *objects.CreateHeader
 }
 
-func SwiftUploadObject(client *gophercloud.ServiceClient, opts SwiftUploadOpts) (*SwiftUploadResults, error) {
-	f, err := os.Open(opts.SourceName)
+// SwiftUpload is an object which has been uploaded to the segments container
+// but which is not yet visible under its own name. Call Commit to make it
+// visible or Abort to discard it.
+type SwiftUpload struct {
+	Size int64
+
+	// SHA256 is the hex encoded SHA-256 hash of the uploaded data.
+	SHA256 string
+
+	client   *gophercloud.ServiceClient
+	opts     SwiftUploadOpts
+	segments []swiftSegment
+}
+
+// swiftSegment is a segment of a Static Large Object manifest. Range limits
+// the segment to the given bytes. Shared segments belong to another object
+// and are never deleted by Abort.
+type swiftSegment struct {
+	Path      string `json:"path"`
+	ETag      string `json:"etag"`
+	SizeBytes int64  `json:"size_bytes"`
+	Range     string `json:"range,omitempty"`
+
+	shared bool
+}
+
+// bounds returns the first and the last byte of the segment which are part
+// of the object. ok is false if Range cannot be parsed.
+func (s swiftSegment) bounds() (first, last int64, ok bool) {
+	first, last = 0, s.SizeBytes-1
+	if s.Range != "" {
+		if _, err := fmt.Sscanf(s.Range, "%d-%d", &first, &last); err != nil || last < first {
+			return 0, 0, false
+		}
+	}
+
+	return first, last, true
+}
+
+// swiftCreateOpts passes the content of an object through as-is. Unlike
+// objects.CreateOpts, it does not read the content into memory in order to
+// calculate an ETag.
+type swiftCreateOpts struct {
+	Content io.Reader
+	Headers map[string]string
+	Query   string
+}
+
+func (opts swiftCreateOpts) ToObjectCreateParams() (io.Reader, map[string]string, string, error) {
+	return opts.Content, opts.Headers, opts.Query, nil
+}
+
+// SwiftUploadStream uploads everything read from src to the segments
+// container of opts.StorageContainer. Each segment holds at most
+// opts.SegmentSize bytes, so objects larger than the Swift object size
+// limit can be uploaded. Only the segment being uploaded is held in a
+// temporary file in opts.TempDir, so its upload can be retried.
+func SwiftUploadStream(client *gophercloud.ServiceClient, opts SwiftUploadOpts, src io.Reader) (*SwiftUpload, error) {
+	if opts.SegmentSize <= 0 {
+		return nil, fmt.Errorf("Invalid segment size: %d", opts.SegmentSize)
+	}
+
+	segmentContainer := opts.StorageContainer + SwiftSegmentSuffix
+	if err := SwiftCreateContainer(client, segmentContainer, true, false); err != nil {
+		return nil, err
+	}
+
+	u := &SwiftUpload{
+		client: client,
+		opts:   opts,
+	}
+
+	// Segments of each upload get their own prefix so they never mix with
+	// the segments of an earlier upload of the same object.
+	prefix := fmt.Sprintf("%s/%d", opts.ObjectName, time.Now().UnixNano())
+	h := sha256.New()
+	r := io.TeeReader(src, h)
+	for n := 0; ; n++ {
+		segment, err := spoolSegment(r, opts.SegmentSize, opts.TempDir)
+		if err != nil {
+			u.Abort()
+			return nil, fmt.Errorf("Unable to read data for %s: %s", opts.ObjectName, err)
+		}
+
+		if segment == nil {
+			break
+		}
+
+		segmentName := fmt.Sprintf("%s/%08d", prefix, n)
+		createOpts := swiftCreateOpts{
+			Content: segment,
+			Headers: map[string]string{},
+		}
+
+		result, err := objects.Create(client, segmentContainer, segmentName, createOpts).Extract()
+		segment.release()
+		if err != nil {
+			u.Abort()
+			return nil, transientError(err, fmt.Errorf("Unable to upload segment %s: %s", segmentName, err))
+		}
+
+		u.segments = append(u.segments, swiftSegment{
+			Path:      "/" + segmentContainer + "/" + segmentName,
+			ETag:      strings.Trim(result.ETag, "\""),
+			SizeBytes: segment.Size(),
+		})
+		u.Size += segment.Size()
+	}
+
+	u.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	return u, nil
+}
+
+// spooledSegment is a segment held in a temporary file while it is
+// uploaded. The file is unlinked as soon as it is created, so it never
+// outlives the process. It can be sent again when a request is retried.
+type spooledSegment struct {
+	*io.SectionReader
+	file *os.File
+}
+
+// spoolSegment writes up to size bytes read from r to a temporary file in
+// dir. nil is returned if r has no more data.
+func spoolSegment(r io.Reader, size int64, dir string) (*spooledSegment, error) {
+	f, err := ioutil.TempFile(dir, "limbo-segment-")
 	if err != nil {
-		return nil, fmt.Errorf("Unable to open file: %s", err)
+		return nil, fmt.Errorf("Unable to create temporary file: %s", err)
 	}
-	defer f.Close()
+	os.Remove(f.Name())
 
-	createOpts := objects.CreateOpts{
-		Content: f,
+	n, err := io.CopyN(f, r, size)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+
+	if n == 0 {
+		f.Close()
+		return nil, nil
+	}
+
+	return &spooledSegment{io.NewSectionReader(f, 0, n), f}, nil
+}
+
+// Close does nothing, as the HTTP client closes request bodies after each
+// attempt. The file is closed by release.
+func (s *spooledSegment) Close() error {
+	return nil
+}
+
+// Replay returns the segment from its start for another attempt.
+func (s *spooledSegment) Replay() (io.ReadCloser, error) {
+	return &spooledSegment{io.NewSectionReader(s.file, 0, s.Size()), s.file}, nil
+}
+
+func (s *spooledSegment) release() error {
+	return s.file.Close()
+}
+
+// Commit makes an uploaded object visible under its name. An object which
+// fits into a single segment is copied in place. Larger objects are made
+// available through a Static Large Object manifest.
+func (u *SwiftUpload) Commit() (*SwiftUploadResults, error) {
+	var createOpts swiftCreateOpts
+	switch len(u.segments) {
+	case 0:
+		createOpts = swiftCreateOpts{
+			Content: bytes.NewReader(nil),
+			Headers: map[string]string{},
+		}
+	case 1:
+		segmentContainer := u.opts.StorageContainer + SwiftSegmentSuffix
+		segmentName := strings.TrimPrefix(u.segments[0].Path, "/"+segmentContainer+"/")
+		copyOpts := SwiftCopyOpts{
+			SourceContainer: segmentContainer,
+			SourceObject:    segmentName,
+			DestContainer:   u.opts.StorageContainer,
+			DestObject:      u.opts.ObjectName,
+			Metadata:        u.opts.Metadata,
+		}
+
+		if err := SwiftCopyObject(u.client, copyOpts); err != nil {
+			return nil, err
+		}
+
+		if err := u.Abort(); err != nil {
+			return nil, err
+		}
+
+		return &SwiftUploadResults{}, nil
+	default:
+		manifest, err := json.Marshal(u.segments)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create manifest: %s", err)
+		}
+
+		createOpts = swiftCreateOpts{
+			Content: bytes.NewReader(manifest),
+			Headers: map[string]string{},
+			Query:   "?multipart-manifest=put",
+		}
+	}
+
+	for k, v := range u.opts.Metadata {
+		createOpts.Headers["X-Object-Meta-"+k] = v
 	}
 
-	result, err := objects.Create(client, opts.StorageContainer, opts.ObjectName, createOpts).Extract()
+	result, err := objects.Create(u.client, u.opts.StorageContainer, u.opts.ObjectName, createOpts).Extract()
 	if err != nil {
-		return nil, fmt.Errorf("Unable to upload file to swift: %s", err)
+		return nil, fmt.Errorf("Unable to upload %s to swift: %s", u.opts.ObjectName, err)
 	}
 
 	s := &SwiftUploadResults{
@@ -167,45 +388,1893 @@ func SwiftUploadObject(client *gophercloud.ServiceClient, opts SwiftUploadOpts)
 	return s, nil
 }
 
+// Abort deletes the uploaded segments.
+func (u *SwiftUpload) Abort() error {
+	segmentContainer := u.opts.StorageContainer + SwiftSegmentSuffix
+	for _, segment := range u.segments {
+		if segment.shared {
+			continue
+		}
+
+		segmentName := strings.TrimPrefix(segment.Path, "/"+segmentContainer+"/")
+		_, err := objects.Delete(u.client, segmentContainer, segmentName, nil).Extract()
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return fmt.Errorf("Unable to delete segment %s: %s", segmentName, err)
+			}
+		}
+	}
+
+	u.segments = nil
+	return nil
+}
+
+// Segments returns the "container/object" paths of the uploaded segments.
+func (u *SwiftUpload) Segments() []string {
+	var segments []string
+	for _, segment := range u.segments {
+		if !segment.shared {
+			segments = append(segments, strings.TrimPrefix(segment.Path, "/"))
+		}
+	}
+
+	return segments
+}
+
 type SwiftDownloadOpts struct {
-	Filename         string
 	ObjectName       string
 	StorageContainer string
 }
 
 type SwiftDownloadResult struct {
 	Headers *objects.DownloadHeader
+
+	// Content streams the object. It must be closed by the caller.
+	Content io.ReadCloser
 }
 
+// SwiftDownloadObject starts the download of an object.
 func SwiftDownloadObject(client *gophercloud.ServiceClient, opts SwiftDownloadOpts) (*SwiftDownloadResult, error) {
-	_, err := objects.Get(client, opts.StorageContainer, opts.ObjectName, nil).Extract()
+	object := objects.Download(client, opts.StorageContainer, opts.ObjectName, nil)
+	if object.Err != nil {
+		if _, ok := object.Err.(gophercloud.ErrDefault404); ok {
+			return nil, ErrObjectDoesNotExist{}
+		}
+
+		return nil, fmt.Errorf("Unable to download object: %s", object.Err)
+	}
+
+	// gophercloud expects a JSON boolean in the X-Static-Large-Object header
+	// and fails to parse the "True" Swift sends for segmented objects.
+	object.Header.Del("X-Static-Large-Object")
+	downloadHeaders, err := object.Extract()
+	if err != nil {
+		object.Body.Close()
+		return nil, fmt.Errorf("Unable to download object: %s", err)
+	}
+
+	result := &SwiftDownloadResult{
+		Headers: downloadHeaders,
+		Content: object.Body,
+	}
+
+	return result, nil
+}
+
+// SwiftObjectExists determines if an object exists.
+func SwiftObjectExists(client *gophercloud.ServiceClient, storageContainer, objectName string) (bool, error) {
+	// Only the status is checked, as the headers of segmented objects
+	// cannot be extracted. See SwiftDownloadObject.
+	err := objects.Get(client, storageContainer, objectName, nil).Err
 	if err != nil {
 		if _, ok := err.(gophercloud.ErrDefault404); ok {
-			return nil, ErrObjectDoesNotExist{}
+			return false, nil
+		}
+
+		return false, fmt.Errorf("Unable to get object %s: %s", objectName, err)
+	}
+
+	return true, nil
+}
+
+// SwiftLatestSuffix is appended to a source name to form the name of the
+// pointer object which refers to its newest export.
+const SwiftLatestSuffix = "@latest"
+
+// SwiftPointerContentType is the content type of pointer objects. It tells
+// pointers apart from images in listings, as pointers with obfuscated names
+// have no @latest suffix.
+const SwiftPointerContentType = "application/x-limbo-pointer"
+
+type SwiftPointerOpts struct {
+	StorageContainer string
+	PointerName      string
+	Target           string
+}
+
+// SwiftUpdatePointer creates or replaces a small pointer object which refers
+// to another object in the same storage container. The name of the target
+// object is stored both as the content and as the Limbo-Pointer metadata of
+// the pointer, which marks the object as a pointer.
+//
+// A single object PUT is atomic in Swift, so readers either see the old or
+// the new target.
+func SwiftUpdatePointer(client *gophercloud.ServiceClient, opts SwiftPointerOpts) error {
+	createOpts := objects.CreateOpts{
+		Content:     strings.NewReader(opts.Target),
+		ContentType: SwiftPointerContentType,
+		Metadata: map[string]string{
+			"Limbo-Pointer": opts.Target,
+		},
+	}
+
+	_, err := objects.Create(client, opts.StorageContainer, opts.PointerName, createOpts).Extract()
+	if err != nil {
+		return fmt.Errorf("Unable to update pointer %s: %s", opts.PointerName, err)
+	}
+
+	return nil
+}
+
+// SwiftResolvePointer returns the name of the object a pointer object refers
+// to. If the object is not a pointer, its own name is returned.
+func SwiftResolvePointer(client *gophercloud.ServiceClient, storageContainer, objectName string) (string, error) {
+	metadata, err := objects.Get(client, storageContainer, objectName, nil).ExtractMetadata()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return "", ErrObjectDoesNotExist{}
 		}
 
+		return "", fmt.Errorf("Unable to get object %s: %s", objectName, err)
+	}
+
+	if v, ok := metadata["Limbo-Pointer"]; ok && v != "" {
+		return v, nil
+	}
+
+	return objectName, nil
+}
+
+// SwiftIsPointer determines if a listed object is a pointer. Pointers
+// created by older versions of limbo are plain text, so the metadata of
+// plain text objects is checked.
+func SwiftIsPointer(client *gophercloud.ServiceClient, storageContainer string, info objects.Object) (bool, error) {
+	if strings.HasSuffix(info.Name, SwiftLatestSuffix) || info.ContentType == SwiftPointerContentType {
+		return true, nil
+	}
+
+	if !strings.HasPrefix(info.ContentType, "text/plain") {
+		return false, nil
+	}
+
+	target, err := SwiftResolvePointer(client, storageContainer, info.Name)
+	if _, ok := err.(ErrObjectDoesNotExist); ok {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return target != info.Name, nil
+}
+
+// SwiftListPointers returns the pointers in a storage container and the
+// names of the objects they refer to.
+func SwiftListPointers(client *gophercloud.ServiceClient, storageContainer string) (map[string]string, error) {
+	infos, err := SwiftListObjectInfo(client, storageContainer, "")
+	if err != nil {
 		return nil, err
 	}
 
-	object := objects.Download(client, opts.StorageContainer, opts.ObjectName, nil)
-	f, err := object.ExtractContent()
+	pointers := map[string]string{}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name, SwiftIndexPrefix) {
+			continue
+		}
+
+		pointer, err := SwiftIsPointer(client, storageContainer, info)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pointer {
+			continue
+		}
+
+		target, err := SwiftResolvePointer(client, storageContainer, info.Name)
+		if _, ok := err.(ErrObjectDoesNotExist); ok {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		pointers[info.Name] = target
+	}
+
+	return pointers, nil
+}
+
+type SwiftCopyOpts struct {
+	SourceContainer string
+	SourceObject    string
+	DestContainer   string
+	DestObject      string
+	Metadata        map[string]string
+}
+
+// SwiftCopyObject copies an object with a server-side COPY, so the data
+// never leaves Swift. Existing metadata is kept and opts.Metadata is added
+// to it.
+func SwiftCopyObject(client *gophercloud.ServiceClient, opts SwiftCopyOpts) error {
+	copyOpts := objects.CopyOpts{
+		Destination: "/" + opts.DestContainer + "/" + opts.DestObject,
+		Metadata:    opts.Metadata,
+	}
+
+	headers, err := copyOpts.ToObjectCopyMap()
 	if err != nil {
-		return nil, fmt.Errorf("Unable to download object: %s", err)
+		return err
 	}
 
-	err = ioutil.WriteFile(opts.Filename, f, 0640)
+	// Copy the manifest of a Static Large Object instead of its content.
+	// The copy shares the segments of the original.
+	url := client.ServiceURL(opts.SourceContainer, opts.SourceObject) + "?multipart-manifest=get"
+	_, err = client.Request("COPY", url, &gophercloud.RequestOpts{
+		MoreHeaders: headers,
+		OkCodes:     []int{201},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Unable to save object: %s", err)
+		return fmt.Errorf("Unable to copy %s/%s to %s/%s: %s",
+			opts.SourceContainer, opts.SourceObject, opts.DestContainer, opts.DestObject, err)
 	}
 
-	downloadHeaders, err := object.Extract()
+	return nil
+}
+
+// SwiftBackup holds copies of objects which are about to be replaced, so
+// they can be put back if replacing a set of objects fails partway.
+type SwiftBackup struct {
+	client           *gophercloud.ServiceClient
+	storageContainer string
+
+	// copies maps the name of each object to the name of its copy in the
+	// segments container. Objects which did not exist map to "".
+	copies map[string]string
+}
+
+// SwiftBackupObjects copies the given objects of a storage container to its
+// segments container. Copies of Static Large Objects share their segments,
+// so backing them up is cheap.
+func SwiftBackupObjects(client *gophercloud.ServiceClient, storageContainer string, names []string) (*SwiftBackup, error) {
+	segmentContainer := storageContainer + SwiftSegmentSuffix
+	if err := SwiftCreateContainer(client, segmentContainer, true, false); err != nil {
+		return nil, err
+	}
+
+	b := &SwiftBackup{
+		client:           client,
+		storageContainer: storageContainer,
+		copies:           map[string]string{},
+	}
+
+	now := time.Now().UnixNano()
+	for _, name := range names {
+		exists, err := SwiftObjectExists(client, storageContainer, name)
+		if err != nil {
+			b.Discard()
+			return nil, err
+		}
+
+		if !exists {
+			b.copies[name] = ""
+			continue
+		}
+
+		copyOpts := SwiftCopyOpts{
+			SourceContainer: storageContainer,
+			SourceObject:    name,
+			DestContainer:   segmentContainer,
+			DestObject:      fmt.Sprintf("%s/%d/backup", name, now),
+		}
+
+		if err := SwiftCopyObject(client, copyOpts); err != nil {
+			b.Discard()
+			return nil, err
+		}
+
+		b.copies[name] = copyOpts.DestObject
+	}
+
+	return b, nil
+}
+
+// Restore puts the copies back in place and deletes the objects which did
+// not exist when they were backed up.
+func (b *SwiftBackup) Restore() error {
+	segmentContainer := b.storageContainer + SwiftSegmentSuffix
+	for name, backupName := range b.copies {
+		if backupName == "" {
+			_, err := objects.Delete(b.client, b.storageContainer, name, nil).Extract()
+			if err != nil {
+				if _, ok := err.(gophercloud.ErrDefault404); !ok {
+					return fmt.Errorf("Unable to delete %s: %s", name, err)
+				}
+			}
+
+			continue
+		}
+
+		copyOpts := SwiftCopyOpts{
+			SourceContainer: segmentContainer,
+			SourceObject:    backupName,
+			DestContainer:   b.storageContainer,
+			DestObject:      name,
+		}
+
+		if err := SwiftCopyObject(b.client, copyOpts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Discard deletes the copies. The segments they share are kept.
+func (b *SwiftBackup) Discard() error {
+	segmentContainer := b.storageContainer + SwiftSegmentSuffix
+	for _, backupName := range b.copies {
+		if backupName == "" {
+			continue
+		}
+
+		_, err := objects.Delete(b.client, segmentContainer, backupName, nil).Extract()
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return fmt.Errorf("Unable to delete %s/%s: %s", segmentContainer, backupName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SwiftPromotionHistoryLength is the longest Limbo-Promotion-History value
+// which is stored. It matches the default max_meta_value_length of Swift.
+const SwiftPromotionHistoryLength = 256
+
+type SwiftPromoteOpts struct {
+	ObjectName    string
+	FromContainer string
+	ToContainer   string
+
+	// RequireVerified refuses images without the Limbo-Verified metadata.
+	// Anyone who can write to the container can set it, so the signature
+	// of a signed image has to be checked with VerifyKeys as well.
+	RequireVerified bool
+
+	// VerifyKeys are used to check the signature of the image before it
+	// is promoted.
+	VerifyKeys []ed25519.PublicKey
+}
+
+type SwiftPromoteResult struct {
+	Objects []string
+	History string
+}
+
+// SwiftPromoteImage copies an exported image from one storage container to
+// another. The rootfs, manifest and signature objects are copied before the
+// meta object so the image only becomes visible in the destination once it
+// is complete.
+// Every promotion is appended to the Limbo-Promotion-History metadata. Only
+// the most recent promotions which fit into SwiftPromotionHistoryLength
+// bytes are kept.
+func SwiftPromoteImage(client *gophercloud.ServiceClient, opts SwiftPromoteOpts) (*SwiftPromoteResult, error) {
+	metadata, err := objects.Get(client, opts.FromContainer, opts.ObjectName, nil).ExtractMetadata()
 	if err != nil {
-		return nil, fmt.Errorf("Unable to extract download headers: %s", err)
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil, ErrObjectDoesNotExist{}
+		}
+
+		return nil, fmt.Errorf("Unable to get object %s: %s", opts.ObjectName, err)
 	}
 
-	result := &SwiftDownloadResult{
-		Headers: downloadHeaders,
+	if opts.RequireVerified && metadata["Limbo-Verified"] == "" {
+		return nil, fmt.Errorf("%s has not been verified", opts.ObjectName)
 	}
 
-	return result, nil
+	signed, err := SwiftObjectExists(client, opts.FromContainer, opts.ObjectName+SwiftSignatureSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	if signed && opts.RequireVerified && len(opts.VerifyKeys) == 0 {
+		return nil, fmt.Errorf("%s is signed. Its signature has to be checked with a verification key", opts.ObjectName)
+	}
+
+	if len(opts.VerifyKeys) > 0 {
+		sig, err := SwiftVerifyImage(client, opts.FromContainer, opts.ObjectName, opts.VerifyKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := SwiftCheckSignedObjects(client, opts.FromContainer, opts.ObjectName, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := fmt.Sprintf("%s->%s@%s", opts.FromContainer, opts.ToContainer,
+		time.Now().UTC().Format(time.RFC3339))
+
+	history := swiftAppendHistory(metadata["Limbo-Promotion-History"], entry)
+
+	// Only the meta object is required. The others are copied if they exist.
+	var names []string
+	for _, suffix := range []string{".root", SwiftManifestSuffix, SwiftSignatureSuffix} {
+		name := opts.ObjectName + suffix
+		exists, err := SwiftObjectExists(client, opts.FromContainer, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			names = append(names, name)
+		}
+	}
+
+	// The index entry of an obfuscated image is copied as well, so its
+	// logical name can be resolved in the destination.
+	indexName := SwiftIndexPrefix + opts.ObjectName
+	exists, err := SwiftObjectExists(client, opts.FromContainer, indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		names = append(names, indexName)
+	}
+
+	names = append(names, opts.ObjectName)
+
+	for _, name := range names {
+		copyOpts := SwiftCopyOpts{
+			SourceContainer: opts.FromContainer,
+			SourceObject:    name,
+			DestContainer:   opts.ToContainer,
+			DestObject:      name,
+			Metadata: map[string]string{
+				"Limbo-Promoted-From":     opts.FromContainer,
+				"Limbo-Promotion-History": history,
+			},
+		}
+
+		if err := SwiftCopyObject(client, copyOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &SwiftPromoteResult{
+		Objects: names,
+		History: history,
+	}
+
+	return result, nil
+}
+
+// swiftAppendHistory appends entry to a comma separated promotion history.
+// The oldest entries are dropped until the history fits into
+// SwiftPromotionHistoryLength bytes.
+func swiftAppendHistory(history, entry string) string {
+	entries := []string{entry}
+	if history != "" {
+		entries = append(strings.Split(history, ","), entry)
+	}
+
+	for len(entries) > 1 && len(strings.Join(entries, ",")) > SwiftPromotionHistoryLength {
+		entries = entries[1:]
+	}
+
+	return strings.Join(entries, ",")
+}
+
+// SwiftGetLabels returns the labels in the metadata of an image.
+func SwiftGetLabels(client *gophercloud.ServiceClient, storageContainer, objectName string) (Labels, error) {
+	metadata, err := objects.Get(client, storageContainer, objectName, nil).ExtractMetadata()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil, ErrObjectDoesNotExist{}
+		}
+
+		return nil, fmt.Errorf("Unable to get object %s: %s", objectName, err)
+	}
+
+	labels, err := ParseLabelString(metadata[LabelsMetadataKey])
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse labels of %s: %s", objectName, err)
+	}
+
+	return labels, nil
+}
+
+// SwiftSetLabels replaces the labels in the metadata of an image.
+func SwiftSetLabels(client *gophercloud.ServiceClient, storageContainer, objectName string, labels Labels) error {
+	return swiftUpdateMetadata(client, storageContainer, objectName, func(metadata map[string]string) {
+		delete(metadata, LabelsMetadataKey)
+		if len(labels) > 0 {
+			metadata[LabelsMetadataKey] = labels.String()
+		}
+	})
+}
+
+// SwiftMarkVerified records in the metadata of an image when it was
+// verified. Promotions can require it.
+func SwiftMarkVerified(client *gophercloud.ServiceClient, storageContainer, objectName string, verified time.Time) error {
+	return swiftUpdateMetadata(client, storageContainer, objectName, func(metadata map[string]string) {
+		metadata["Limbo-Verified"] = verified.UTC().Format(time.RFC3339)
+	})
+}
+
+// swiftUpdateMetadata changes the metadata of an object. A POST replaces
+// all metadata of an object, so the metadata which is not changed is sent
+// along.
+func swiftUpdateMetadata(client *gophercloud.ServiceClient, storageContainer, objectName string, update func(map[string]string)) error {
+	metadata, err := objects.Get(client, storageContainer, objectName, nil).ExtractMetadata()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return ErrObjectDoesNotExist{}
+		}
+
+		return fmt.Errorf("Unable to get object %s: %s", objectName, err)
+	}
+
+	update(metadata)
+
+	updateOpts := objects.UpdateOpts{
+		Metadata: metadata,
+	}
+
+	if _, err := objects.Update(client, storageContainer, objectName, updateOpts).Extract(); err != nil {
+		return fmt.Errorf("Unable to update metadata of %s: %s", objectName, err)
+	}
+
+	return nil
+}
+
+// SwiftSignatureSuffix is appended to the name of an image to form the name
+// of its detached signature object.
+const SwiftSignatureSuffix = ".sig"
+
+type SwiftSignOpts struct {
+	StorageContainer string
+	ObjectName       string
+	Objects          []SignedObject
+	Key              ed25519.PrivateKey
+}
+
+// SwiftSignImage signs the objects of an image and stores the detached
+// signature next to it.
+func SwiftSignImage(client *gophercloud.ServiceClient, opts SwiftSignOpts) error {
+	sig := ImageSignature{
+		Image:   opts.ObjectName,
+		Created: time.Now().UTC(),
+		Objects: opts.Objects,
+	}
+
+	data, err := SignImage(sig, opts.Key)
+	if err != nil {
+		return err
+	}
+
+	createOpts := objects.CreateOpts{
+		Content:     bytes.NewReader(data),
+		ContentType: "application/json",
+	}
+
+	name := opts.ObjectName + SwiftSignatureSuffix
+	if _, err := objects.Create(client, opts.StorageContainer, name, createOpts).Extract(); err != nil {
+		return fmt.Errorf("Unable to upload signature %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// SwiftVerifyImage downloads the detached signature of an image and
+// verifies it with the given keys. Images without a signature are refused.
+func SwiftVerifyImage(client *gophercloud.ServiceClient, storageContainer, objectName string, keys []ed25519.PublicKey) (*ImageSignature, error) {
+	name := objectName + SwiftSignatureSuffix
+	downloadOpts := SwiftDownloadOpts{
+		StorageContainer: storageContainer,
+		ObjectName:       name,
+	}
+
+	result, err := SwiftDownloadObject(client, downloadOpts)
+	if err != nil {
+		if _, ok := err.(ErrObjectDoesNotExist); ok {
+			return nil, fmt.Errorf("%s is not signed", objectName)
+		}
+
+		return nil, err
+	}
+	defer result.Content.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(result.Content, 1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to download signature %s: %s", name, err)
+	}
+
+	sig, err := VerifyImage(data, keys)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to verify %s: %s", objectName, err)
+	}
+
+	if sig.Image != objectName {
+		return nil, fmt.Errorf("Signature of %s was made for %s", objectName, sig.Image)
+	}
+
+	return sig, nil
+}
+
+// SwiftCheckSignedObjects checks the objects of an image against its
+// verified signature before any data is downloaded: every signed object
+// has to exist with the signed size, and the rootfs and manifest of the
+// image have to be signed if they exist. The content of the objects can
+// only be checked while it is downloaded.
+func SwiftCheckSignedObjects(client *gophercloud.ServiceClient, storageContainer, objectName string, sig *ImageSignature) error {
+	infos, err := SwiftListObjectInfo(client, storageContainer, objectName)
+	if err != nil {
+		return err
+	}
+
+	sizes := map[string]int64{}
+	for _, info := range infos {
+		sizes[info.Name] = info.Bytes
+	}
+
+	for _, object := range sig.Objects {
+		size, ok := sizes[object.Name]
+		if !ok {
+			return fmt.Errorf("Signed object %s does not exist", object.Name)
+		}
+
+		if size != object.Size {
+			return fmt.Errorf("%s has %d bytes, but %d bytes were signed", object.Name, size, object.Size)
+		}
+	}
+
+	for _, name := range []string{objectName, objectName + ".root", objectName + SwiftManifestSuffix} {
+		if _, ok := sizes[name]; ok && sig.Object(name) == nil {
+			return fmt.Errorf("%s is not covered by the signature", name)
+		}
+	}
+
+	return nil
+}
+
+// SwiftManifestSuffix is appended to the name of an image to form the name
+// of its manifest object.
+const SwiftManifestSuffix = ".manifest.json"
+
+type SwiftManifestOpts struct {
+	StorageContainer string
+	ObjectName       string
+	Manifest         Manifest
+
+	// CryptOpts encrypts the manifest if set. Manifests are only
+	// encrypted if they would reveal an obfuscated name.
+	CryptOpts *CryptOpts
+}
+
+// SwiftWriteManifest stores the manifest of an image and returns the
+// description of the stored object, so it can be signed.
+func SwiftWriteManifest(client *gophercloud.ServiceClient, opts SwiftManifestOpts) (*SignedObject, error) {
+	data, err := json.MarshalIndent(opts.Manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode manifest: %s", err)
+	}
+
+	contentType := "application/json"
+	if opts.CryptOpts != nil {
+		var b bytes.Buffer
+		if err := Encrypt(&b, bytes.NewReader(data), *opts.CryptOpts); err != nil {
+			return nil, err
+		}
+
+		data = b.Bytes()
+		contentType = "application/octet-stream"
+	}
+
+	createOpts := objects.CreateOpts{
+		Content:     bytes.NewReader(data),
+		ContentType: contentType,
+	}
+
+	name := opts.ObjectName + SwiftManifestSuffix
+	if _, err := objects.Create(client, opts.StorageContainer, name, createOpts).Extract(); err != nil {
+		return nil, fmt.Errorf("Unable to upload manifest %s: %s", name, err)
+	}
+
+	sum := sha256.Sum256(data)
+	signed := &SignedObject{
+		Name:   name,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+
+	return signed, nil
+}
+
+// SwiftReadManifest downloads the manifest of an image, decrypting it with
+// cryptOpts if it is encrypted. If signed is set, the manifest must match
+// it. ErrObjectDoesNotExist is returned for images exported without a
+// manifest.
+func SwiftReadManifest(client *gophercloud.ServiceClient, storageContainer, objectName string, cryptOpts CryptOpts, signed *SignedObject) (*Manifest, error) {
+	name := objectName + SwiftManifestSuffix
+	data, err := swiftDownloadManifest(client, storageContainer, objectName, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := manifestEncrypted(data)
+	if encrypted {
+		var b bytes.Buffer
+		if err := Decrypt(&b, bytes.NewReader(data), cryptOpts); err != nil {
+			return nil, fmt.Errorf("Unable to decrypt manifest %s: %s", name, err)
+		}
+		data = b.Bytes()
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("Unable to parse manifest %s: %s", name, err)
+	}
+	m.Encrypted = encrypted
+
+	return &m, nil
+}
+
+// swiftDownloadManifest downloads the manifest of an image as it is stored.
+func swiftDownloadManifest(client *gophercloud.ServiceClient, storageContainer, objectName string, signed *SignedObject) ([]byte, error) {
+	name := objectName + SwiftManifestSuffix
+	downloadOpts := SwiftDownloadOpts{
+		StorageContainer: storageContainer,
+		ObjectName:       name,
+	}
+
+	result, err := SwiftDownloadObject(client, downloadOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Content.Close()
+
+	var r io.Reader = io.LimitReader(result.Content, 1024*1024)
+	if signed != nil {
+		r = NewVerifyingReader(r, *signed)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to download manifest %s: %s", name, err)
+	}
+
+	return data, nil
+}
+
+// manifestEncrypted determines if a stored manifest is encrypted. Plain
+// manifests are JSON objects.
+func manifestEncrypted(data []byte) bool {
+	return !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// SwiftListObjects returns the names of all objects in a storage container
+// which start with prefix.
+func SwiftListObjects(client *gophercloud.ServiceClient, storageContainer, prefix string) ([]string, error) {
+	infos, err := SwiftListObjectInfo(client, storageContainer, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+
+	return names, nil
+}
+
+// SwiftListObjectInfo returns the objects in a storage container whose
+// names start with prefix, together with their size and modification time.
+// Listings of large containers are fetched page by page.
+func SwiftListObjectInfo(client *gophercloud.ServiceClient, storageContainer, prefix string) ([]objects.Object, error) {
+	var infos []objects.Object
+	listOpts := objects.ListOpts{
+		Full:   true,
+		Prefix: prefix,
+	}
+
+	err := objects.List(client, storageContainer, listOpts).EachPage(func(page pagination.Page) (bool, error) {
+		pageInfos, err := objects.ExtractInfo(page)
+		if err != nil {
+			return false, err
+		}
+
+		infos = append(infos, pageInfos...)
+		return true, nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list objects in %s: %s", storageContainer, err)
+	}
+
+	return infos, nil
+}
+
+// SwiftImageSummary describes a stored image for listings.
+type SwiftImageSummary struct {
+	Name        string
+	Created     time.Time
+	Size        int64
+	Fingerprint string
+	Encrypted   bool
+	Signed      bool
+
+	// Versions is the number of older versions of the image kept in the
+	// archive container.
+	Versions int
+
+	Labels Labels
+
+	// Manifest is nil if the image has no manifest or if it is encrypted.
+	Manifest *Manifest
+
+	// LogicalName is the name an image with an obfuscated name was
+	// exported under. It is only known once the index has been read.
+	LogicalName string
+}
+
+// DisplayName returns the logical name of an image if it is known and its
+// object name otherwise.
+func (s SwiftImageSummary) DisplayName() string {
+	if s.LogicalName != "" {
+		return s.LogicalName
+	}
+
+	return s.Name
+}
+
+// SwiftListImages groups the objects in a storage container whose names
+// start with prefix into images. Details are taken from the manifest of an
+// image if it has a readable one, and from the listing otherwise.
+func SwiftListImages(client *gophercloud.ServiceClient, storageContainer, prefix string) ([]SwiftImageSummary, error) {
+	infos, err := SwiftListObjectInfo(client, storageContainer, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]objects.Object{}
+	for _, info := range infos {
+		byName[info.Name] = info
+	}
+
+	versions, err := swiftArchiveVersions(client, storageContainer)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []SwiftImageSummary
+	for _, info := range infos {
+		if !SwiftIsImage(info.Name) {
+			continue
+		}
+
+		// Pointers with obfuscated names are only told apart by their
+		// content type or metadata.
+		pointer, err := SwiftIsPointer(client, storageContainer, info)
+		if err != nil {
+			return nil, err
+		}
+
+		if pointer {
+			continue
+		}
+
+		image := SwiftImageSummary{
+			Name:     info.Name,
+			Created:  info.LastModified,
+			Size:     info.Bytes,
+			Versions: versions[info.Name],
+		}
+
+		if rootfs, ok := byName[info.Name+".root"]; ok {
+			image.Size += rootfs.Bytes
+		}
+
+		_, image.Signed = byName[info.Name+SwiftSignatureSuffix]
+
+		if _, ok := byName[info.Name+SwiftManifestSuffix]; ok {
+			data, err := swiftDownloadManifest(client, storageContainer, info.Name, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			// Only obfuscated images have encrypted manifests.
+			if manifestEncrypted(data) {
+				image.Encrypted = true
+			} else {
+				var m Manifest
+				if err := json.Unmarshal(data, &m); err != nil {
+					return nil, fmt.Errorf("Unable to parse manifest of %s: %s", info.Name, err)
+				}
+
+				image.Manifest = &m
+				image.Created = m.Created
+				image.Size = m.Size()
+				image.Fingerprint = m.Fingerprint
+				image.Encrypted = m.Encryption != "none"
+				image.Labels = m.Labels
+			}
+		} else {
+			image.Encrypted, err = swiftHasCryptHeader(client, storageContainer, info.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Labels are kept in the metadata of images whose manifest cannot
+		// be read.
+		if image.Manifest == nil {
+			image.Labels, err = SwiftGetLabels(client, storageContainer, info.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		images = append(images, image)
+	}
+
+	return images, nil
+}
+
+// swiftHasCryptHeader determines if an object starts with the header of
+// data encrypted by limbo. Only the first bytes are downloaded.
+func swiftHasCryptHeader(client *gophercloud.ServiceClient, storageContainer, objectName string) (bool, error) {
+	// objects.Download does not accept a partial response.
+	resp, err := client.Get(client.ServiceURL(storageContainer, objectName), nil, &gophercloud.RequestOpts{
+		MoreHeaders: map[string]string{
+			"Range": fmt.Sprintf("bytes=0-%d", len(cryptMagic)-1),
+		},
+		OkCodes: []int{200, 206},
+	})
+	if err != nil {
+		return false, fmt.Errorf("Unable to download %s: %s", objectName, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(len(cryptMagic))))
+	if err != nil {
+		return false, fmt.Errorf("Unable to download %s: %s", objectName, err)
+	}
+
+	return bytes.HasPrefix(data, cryptMagic), nil
+}
+
+// swiftArchivePrefix returns the prefix of the archived versions of an
+// object. Swift names them <length of name as 3 hex digits><name>/<timestamp>.
+func swiftArchivePrefix(objectName string) string {
+	return fmt.Sprintf("%03x%s/", len(objectName), objectName)
+}
+
+// swiftArchiveVersions counts the versions of each object in the archive
+// container of a storage container. See swiftArchivePrefix.
+func swiftArchiveVersions(client *gophercloud.ServiceClient, storageContainer string) (map[string]int, error) {
+	versions := map[string]int{}
+	archiveName := storageContainer + "_archive"
+
+	_, err := containers.Get(client, archiveName).Extract()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return versions, nil
+		}
+
+		return nil, fmt.Errorf("Unable to get archive container: %s", err)
+	}
+
+	names, err := SwiftListObjects(client, archiveName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		var length int
+		if len(name) < 3 {
+			continue
+		}
+
+		if _, err := fmt.Sscanf(name[:3], "%03x", &length); err != nil || len(name) < 3+length+1 {
+			continue
+		}
+
+		if name[3+length] == '/' {
+			versions[name[3:3+length]]++
+		}
+	}
+
+	return versions, nil
+}
+
+// SwiftObjectSegments returns the segments of a Static Large Object as
+// "container/object" paths. Other objects have no segments.
+func SwiftObjectSegments(client *gophercloud.ServiceClient, storageContainer, objectName string) ([]string, error) {
+	manifest, err := swiftObjectManifest(client, storageContainer, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, segment := range manifest {
+		segments = append(segments, strings.TrimPrefix(segment.Path, "/"))
+	}
+
+	return segments, nil
+}
+
+// swiftObjectManifest returns the manifest of a Static Large Object. Its
+// segments are marked as shared, so they can be used in the manifest of
+// another upload. nil is returned for other objects.
+func swiftObjectManifest(client *gophercloud.ServiceClient, storageContainer, objectName string) ([]swiftSegment, error) {
+	downloadOpts := objects.DownloadOpts{
+		MultipartManifest: "get",
+	}
+
+	result := objects.Download(client, storageContainer, objectName, downloadOpts)
+	if result.Err != nil {
+		if _, ok := result.Err.(gophercloud.ErrDefault404); ok {
+			return nil, ErrObjectDoesNotExist{}
+		}
+
+		return nil, fmt.Errorf("Unable to get object %s: %s", objectName, result.Err)
+	}
+
+	if !strings.EqualFold(result.Header.Get("X-Static-Large-Object"), "true") {
+		result.Body.Close()
+		return nil, nil
+	}
+
+	content, err := result.ExtractContent()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get manifest of %s: %s", objectName, err)
+	}
+
+	// Swift returns stored manifests in a different format than the one
+	// used to create them.
+	var manifest []struct {
+		Name  string `json:"name"`
+		Hash  string `json:"hash"`
+		Bytes int64  `json:"bytes"`
+		Range string `json:"range"`
+	}
+
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("Unable to parse manifest of %s: %s", objectName, err)
+	}
+
+	var segments []swiftSegment
+	for _, segment := range manifest {
+		segments = append(segments, swiftSegment{
+			Path:      "/" + strings.TrimPrefix(segment.Name, "/"),
+			ETag:      segment.Hash,
+			SizeBytes: segment.Bytes,
+			Range:     segment.Range,
+			shared:    true,
+		})
+	}
+
+	return segments, nil
+}
+
+// swiftSkipSegments returns the segments which hold the data of an object
+// after its first n bytes. The first returned segment is limited to a range
+// if needed. ok is false if a range of the manifest cannot be parsed or the
+// object is not larger than n bytes.
+func swiftSkipSegments(segments []swiftSegment, n int64) ([]swiftSegment, bool) {
+	for i, segment := range segments {
+		first, last, ok := segment.bounds()
+		if !ok {
+			return nil, false
+		}
+
+		if length := last - first + 1; n >= length {
+			n -= length
+			continue
+		}
+
+		skipped := append([]swiftSegment{}, segments[i:]...)
+		if n > 0 {
+			skipped[0].Range = fmt.Sprintf("%d-%d", first+n, last)
+		}
+
+		return skipped, true
+	}
+
+	return nil, false
+}
+
+// SwiftDeleteSegments deletes the given "container/object" paths. Segments
+// which no longer exist are ignored.
+func SwiftDeleteSegments(client *gophercloud.ServiceClient, segments []string) error {
+	for _, segment := range segments {
+		parts := strings.SplitN(segment, "/", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid segment path: %s", segment)
+		}
+
+		_, err := objects.Delete(client, parts[0], parts[1], nil).Extract()
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return fmt.Errorf("Unable to delete segment %s: %s", segment, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+type SwiftDeleteResult struct {
+	// Objects are the deleted objects, including archived versions.
+	Objects []string
+
+	// Segments are the deleted segments.
+	Segments []string
+
+	// SharedSegments are the segments which were kept because objects in
+	// other storage containers, such as promoted copies, still use them.
+	SharedSegments []string
+}
+
+// SwiftDeleteImage deletes the meta, rootfs, manifest and signature objects
+// of an image, its index entry, the versions of these objects kept in the
+// archive container and their segments.
+//
+// Archived versions are deleted first, as Swift restores the previous
+// version of an object in an archived container when it is deleted.
+func SwiftDeleteImage(client *gophercloud.ServiceClient, storageContainer, objectName string) (*SwiftDeleteResult, error) {
+	names := []string{objectName}
+	for _, suffix := range []string{".root", SwiftManifestSuffix, SwiftSignatureSuffix} {
+		names = append(names, objectName+suffix)
+	}
+	names = append(names, SwiftIndexPrefix+objectName)
+
+	archiveName := storageContainer + "_archive"
+	archiveExists, err := swiftArchiveExists(client, storageContainer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the objects and their segments before deleting anything.
+	type object struct {
+		container string
+		name      string
+	}
+
+	var targets []object
+	var segments, segmented []string
+	for _, name := range names {
+		if archiveExists {
+			versions, err := SwiftListObjects(client, archiveName, swiftArchivePrefix(name))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, version := range versions {
+				targets = append(targets, object{archiveName, version})
+			}
+		}
+
+		targets = append(targets, object{storageContainer, name})
+	}
+
+	var existing []object
+	for _, o := range targets {
+		objectSegments, err := SwiftObjectSegments(client, o.container, o.name)
+		if _, ok := err.(ErrObjectDoesNotExist); ok {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		existing = append(existing, o)
+		if len(objectSegments) > 0 {
+			segments = append(segments, objectSegments...)
+			if o.container == storageContainer {
+				segmented = append(segmented, o.name)
+			}
+		}
+	}
+
+	inUse, err := swiftSegmentsInUse(client, storageContainer, segmented)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SwiftDeleteResult{}
+	for _, o := range existing {
+		_, err := objects.Delete(client, o.container, o.name, nil).Extract()
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return result, fmt.Errorf("Unable to delete %s/%s: %s", o.container, o.name, err)
+			}
+		}
+
+		result.Objects = append(result.Objects, o.container+"/"+o.name)
+	}
+
+	seen := map[string]bool{}
+	for _, segment := range segments {
+		if seen[segment] {
+			continue
+		}
+		seen[segment] = true
+
+		if inUse[segment] {
+			result.SharedSegments = append(result.SharedSegments, segment)
+		} else {
+			result.Segments = append(result.Segments, segment)
+		}
+	}
+
+	if err := SwiftDeleteSegments(client, result.Segments); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// swiftSegmentsInUse returns the segments used by objects with the given
+// names in storage containers other than storageContainer and its archive.
+// Promoted copies keep the name of the original and share its segments.
+func swiftSegmentsInUse(client *gophercloud.ServiceClient, storageContainer string, names []string) (map[string]bool, error) {
+	inUse := map[string]bool{}
+	if len(names) == 0 {
+		return inUse, nil
+	}
+
+	var containerNames []string
+	err := containers.List(client, nil).EachPage(func(page pagination.Page) (bool, error) {
+		pageNames, err := containers.ExtractNames(page)
+		if err != nil {
+			return false, err
+		}
+
+		containerNames = append(containerNames, pageNames...)
+		return true, nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list storage containers: %s", err)
+	}
+
+	for _, container := range containerNames {
+		if container == storageContainer || container == storageContainer+"_archive" ||
+			strings.HasSuffix(container, SwiftSegmentSuffix) {
+			continue
+		}
+
+		for _, name := range names {
+			candidates := []string{name}
+			if strings.HasSuffix(container, "_archive") {
+				versions, err := SwiftListObjects(client, container, swiftArchivePrefix(name))
+				if err != nil {
+					return nil, err
+				}
+				candidates = versions
+			}
+
+			for _, candidate := range candidates {
+				segments, err := SwiftObjectSegments(client, container, candidate)
+				if _, ok := err.(ErrObjectDoesNotExist); ok {
+					continue
+				}
+
+				if err != nil {
+					return nil, err
+				}
+
+				for _, segment := range segments {
+					inUse[segment] = true
+				}
+			}
+		}
+	}
+
+	return inUse, nil
+}
+
+// SwiftDeleteUnusedSegments deletes those of the given segments which are
+// no longer used by the objects with the given names, their archived
+// versions or copies of them in other storage containers, such as promoted
+// copies. The segments which are kept are returned as SharedSegments.
+func SwiftDeleteUnusedSegments(client *gophercloud.ServiceClient, storageContainer string, objectNames []string, segments []string) (*SwiftDeleteResult, error) {
+	result := &SwiftDeleteResult{}
+	if len(segments) == 0 {
+		return result, nil
+	}
+
+	inUse, err := swiftSegmentsInUse(client, storageContainer, objectNames)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveName := storageContainer + "_archive"
+	archiveExists, err := swiftArchiveExists(client, storageContainer)
+	if err != nil {
+		return nil, err
+	}
+
+	holders := map[string][]string{
+		storageContainer: objectNames,
+	}
+
+	for _, objectName := range objectNames {
+		if !archiveExists {
+			break
+		}
+
+		versions, err := SwiftListObjects(client, archiveName, swiftArchivePrefix(objectName))
+		if err != nil {
+			return nil, err
+		}
+
+		holders[archiveName] = append(holders[archiveName], versions...)
+	}
+
+	for container, names := range holders {
+		for _, name := range names {
+			objectSegments, err := SwiftObjectSegments(client, container, name)
+			if _, ok := err.(ErrObjectDoesNotExist); ok {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, segment := range objectSegments {
+				inUse[segment] = true
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, segment := range segments {
+		if seen[segment] {
+			continue
+		}
+		seen[segment] = true
+
+		if inUse[segment] {
+			result.SharedSegments = append(result.SharedSegments, segment)
+		} else {
+			result.Segments = append(result.Segments, segment)
+		}
+	}
+
+	if err := SwiftDeleteSegments(client, result.Segments); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// SwiftDeleteObjects deletes objects and their archived versions. Archived
+// versions are deleted first, as Swift would otherwise put them back in
+// place. Segments are kept.
+func SwiftDeleteObjects(client *gophercloud.ServiceClient, storageContainer string, names []string) error {
+	archiveName := storageContainer + "_archive"
+	archiveExists, err := swiftArchiveExists(client, storageContainer)
+	if err != nil {
+		return err
+	}
+
+	remove := func(container, name string) error {
+		_, err := objects.Delete(client, container, name, nil).Extract()
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return fmt.Errorf("Unable to delete %s/%s: %s", container, name, err)
+			}
+		}
+
+		return nil
+	}
+
+	for _, name := range names {
+		if archiveExists {
+			versions, err := SwiftListObjects(client, archiveName, swiftArchivePrefix(name))
+			if err != nil {
+				return err
+			}
+
+			for _, version := range versions {
+				if err := remove(archiveName, version); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := remove(storageContainer, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// swiftArchiveExists reports whether the archive container of a storage
+// container exists.
+func swiftArchiveExists(client *gophercloud.ServiceClient, storageContainer string) (bool, error) {
+	if _, err := containers.Get(client, storageContainer+"_archive").Extract(); err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("Unable to get archive container: %s", err)
+	}
+
+	return true, nil
+}
+
+// SwiftPointerTargets returns the names of the objects the @latest pointers
+// in a storage container refer to.
+func SwiftPointerTargets(client *gophercloud.ServiceClient, storageContainer string) (map[string]bool, error) {
+	pointers, err := SwiftListPointers(client, storageContainer)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := map[string]bool{}
+	for _, target := range pointers {
+		targets[target] = true
+	}
+
+	return targets, nil
+}
+
+type SwiftRekeyOpts struct {
+	StorageContainer string
+	ObjectName       string
+	SegmentSize      int64
+	TempDir          string
+	OldCrypt         CryptOpts
+	NewCrypt         CryptOpts
+
+	// NewObjectName is the name the re-encrypted object is uploaded under.
+	// If unset, the object keeps its name.
+	NewObjectName string
+
+	// Legacy allows data without an encryption header, which was encrypted
+	// by older versions of limbo.
+	Legacy bool
+
+	// Signed is checked against the data before it is re-encrypted.
+	Signed *SignedObject
+}
+
+type SwiftRekeyResult struct {
+	// Upload holds the re-encrypted object. It replaces the original once
+	// it is committed.
+	Upload *SwiftUpload
+
+	// OldSegments are the segments of the original object. If only the
+	// header was rewritten, the upload still uses most of them.
+	OldSegments []string
+}
+
+// SwiftRekeyObject streams an encrypted object through Rekey and uploads the
+// result under the same or a new name. The metadata of the object is kept. Nothing
+// is replaced until the returned upload is committed.
+//
+// If only the data key of a segmented object has to be wrapped again, just
+// a new header is uploaded. The upload refers to the segments of the
+// original object for the encrypted data, which is downloaded only to be
+// hashed and checked.
+func SwiftRekeyObject(client *gophercloud.ServiceClient, opts SwiftRekeyOpts) (*SwiftRekeyResult, error) {
+	metadata, err := objects.Get(client, opts.StorageContainer, opts.ObjectName, nil).ExtractMetadata()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil, ErrObjectDoesNotExist{}
+		}
+
+		return nil, fmt.Errorf("Unable to get object %s: %s", opts.ObjectName, err)
+	}
+
+	manifest, err := swiftObjectManifest(client, opts.StorageContainer, opts.ObjectName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SwiftRekeyResult{}
+	for _, segment := range manifest {
+		result.OldSegments = append(result.OldSegments, strings.TrimPrefix(segment.Path, "/"))
+	}
+
+	downloadOpts := SwiftDownloadOpts{
+		StorageContainer: opts.StorageContainer,
+		ObjectName:       opts.ObjectName,
+	}
+
+	download, err := SwiftDownloadObject(client, downloadOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer download.Content.Close()
+
+	var r io.Reader = download.Content
+	if opts.Signed != nil {
+		r = NewVerifyingReader(r, *opts.Signed)
+	}
+
+	br := bufio.NewReader(r)
+	if !HasCryptHeader(br) && !opts.Legacy {
+		return nil, fmt.Errorf("%s is not encrypted", opts.ObjectName)
+	}
+
+	uploadOpts := SwiftUploadOpts{
+		StorageContainer: opts.StorageContainer,
+		ObjectName:       opts.ObjectName,
+		SegmentSize:      opts.SegmentSize,
+		TempDir:          opts.TempDir,
+		Metadata:         metadata,
+	}
+
+	if opts.NewObjectName != "" {
+		uploadOpts.ObjectName = opts.NewObjectName
+	}
+
+	header, oldHeaderSize, err := RekeyHeader(br, opts.OldCrypt, opts.NewCrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted io.Reader
+	if header != nil {
+		segments, ok := swiftSkipSegments(manifest, int64(oldHeaderSize))
+		if ok {
+			result.Upload, err = swiftUploadHeader(client, uploadOpts, header, segments, br)
+			if err != nil {
+				return nil, err
+			}
+
+			return result, nil
+		}
+
+		encrypted = io.MultiReader(bytes.NewReader(header), br)
+	} else {
+		rekeyed := NewStreamReader(func(w io.Writer) error {
+			return Rekey(w, br, opts.OldCrypt, opts.NewCrypt)
+		})
+		defer rekeyed.Close()
+
+		encrypted = rekeyed
+	}
+
+	result.Upload, err = SwiftUploadStream(client, uploadOpts, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// swiftUploadHeader uploads header as the first segment of an object whose
+// remaining data is held by segments. rest is the content of these
+// segments. It is read to hash the whole object, but is not uploaded.
+func swiftUploadHeader(client *gophercloud.ServiceClient, opts SwiftUploadOpts, header []byte, segments []swiftSegment, rest io.Reader) (*SwiftUpload, error) {
+	var restSize int64
+	for _, segment := range segments {
+		first, last, _ := segment.bounds()
+		restSize += last - first + 1
+	}
+
+	h := sha256.New()
+	h.Write(header)
+	n, err := io.Copy(h, rest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read data for %s: %s", opts.ObjectName, err)
+	}
+
+	if n != restSize {
+		return nil, fmt.Errorf("%s has %d bytes, but its segments hold %d bytes", opts.ObjectName, n, restSize)
+	}
+
+	segmentContainer := opts.StorageContainer + SwiftSegmentSuffix
+	segmentName := fmt.Sprintf("%s/%d/%08d", opts.ObjectName, time.Now().UnixNano(), 0)
+	createOpts := swiftCreateOpts{
+		Content: bytes.NewReader(header),
+		Headers: map[string]string{},
+	}
+
+	created, err := objects.Create(client, segmentContainer, segmentName, createOpts).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to upload segment %s: %s", segmentName, err)
+	}
+
+	u := &SwiftUpload{
+		Size:   int64(len(header)) + n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		client: client,
+		opts:   opts,
+	}
+
+	u.segments = append(u.segments, swiftSegment{
+		Path:      "/" + segmentContainer + "/" + segmentName,
+		ETag:      strings.Trim(created.ETag, "\""),
+		SizeBytes: int64(len(header)),
+	})
+	u.segments = append(u.segments, segments...)
+
+	return u, nil
+}
+
+type SwiftVerifyOpts struct {
+	StorageContainer string
+	ObjectName       string
+
+	// Meta is set for the meta object of an image, which must hold a
+	// metadata.yaml, and unset for its rootfs.
+	Meta bool
+
+	// Manifest and Signed describe the stored data. Either may be nil.
+	Manifest *ManifestObject
+	Signed   *SignedObject
+
+	// CryptOpts decrypt the object so its content can be checked. If it is
+	// nil, the content of an encrypted object is not checked.
+	CryptOpts *CryptOpts
+
+	// Legacy allows data without an encryption header, which was encrypted
+	// by older versions of limbo.
+	Legacy bool
+
+	// Fingerprint receives the content of the object if it is checked.
+	Fingerprint hash.Hash
+}
+
+type SwiftVerifyResult struct {
+	Size   int64
+	SHA256 string
+
+	// Checked is set if the content of the object was checked.
+	Checked bool
+}
+
+// SwiftVerifyObject downloads an object and checks it against its manifest
+// entry and its signature. If the object is not encrypted, or can be
+// decrypted, its content is checked by CheckImageFile as well. Nothing is
+// stored locally.
+func SwiftVerifyObject(client *gophercloud.ServiceClient, opts SwiftVerifyOpts) (*SwiftVerifyResult, error) {
+	downloadOpts := SwiftDownloadOpts{
+		StorageContainer: opts.StorageContainer,
+		ObjectName:       opts.ObjectName,
+	}
+
+	download, err := SwiftDownloadObject(client, downloadOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer download.Content.Close()
+
+	sum := sha256.New()
+	counter := &countingReader{r: download.Content}
+	br := bufio.NewReader(io.TeeReader(counter, sum))
+
+	fingerprint := opts.Fingerprint
+	if fingerprint == nil {
+		fingerprint = sha256.New()
+	}
+
+	result := &SwiftVerifyResult{}
+	var contentErr, decryptErr error
+	switch {
+	case !HasCryptHeader(br) && !opts.Legacy:
+		contentErr = CheckImageFile(io.TeeReader(br, fingerprint), opts.Meta)
+		result.Checked = true
+	case opts.CryptOpts != nil:
+		plain := NewStreamReader(func(w io.Writer) error {
+			decryptErr = Decrypt(w, br, *opts.CryptOpts)
+			return decryptErr
+		})
+
+		contentErr = CheckImageFile(io.TeeReader(plain, fingerprint), opts.Meta)
+		plain.Close()
+		result.Checked = true
+	}
+
+	// The rest of the object is read, so all of it is hashed.
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return nil, fmt.Errorf("Unable to download %s: %s", opts.ObjectName, err)
+	}
+
+	if counter.err != nil {
+		return nil, fmt.Errorf("Unable to download %s: %s", opts.ObjectName, counter.err)
+	}
+
+	result.Size = counter.n
+	result.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	if o := opts.Manifest; o != nil && (o.Size != result.Size || o.SHA256 != result.SHA256) {
+		return result, fmt.Errorf("%s does not match the manifest", opts.ObjectName)
+	}
+
+	if o := opts.Signed; o != nil && (o.Size != result.Size || o.SHA256 != result.SHA256) {
+		return result, fmt.Errorf("%s does not match its signature", opts.ObjectName)
+	}
+
+	if decryptErr != nil {
+		return result, fmt.Errorf("Unable to decrypt %s: %s", opts.ObjectName, decryptErr)
+	}
+
+	if contentErr != nil {
+		return result, fmt.Errorf("%s is damaged: %s", opts.ObjectName, contentErr)
+	}
+
+	return result, nil
+}
+
+// SwiftIsImage determines if an object is the meta object of an image, as
+// opposed to the rootfs, manifest or signature of an image, a pointer or an
+// index entry. Only the name is checked, so pointers with obfuscated names
+// have to be recognised with SwiftIsPointer.
+func SwiftIsImage(objectName string) bool {
+	if strings.HasPrefix(objectName, SwiftIndexPrefix) {
+		return false
+	}
+
+	for _, suffix := range []string{".root", SwiftManifestSuffix, SwiftSignatureSuffix, SwiftLatestSuffix} {
+		if strings.HasSuffix(objectName, suffix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SwiftIndexPrefix is the prefix of the index entries which map logical
+// names to the opaque names of obfuscated objects. Each entry is a separate
+// object, so hosts which can only encrypt are able to add entries.
+const SwiftIndexPrefix = "limbo-index/"
+
+// SwiftAddIndexEntry encrypts an index entry and stores it.
+func SwiftAddIndexEntry(client *gophercloud.ServiceClient, storageContainer string, entry IndexEntry, cryptOpts CryptOpts) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Unable to encode index entry: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err := Encrypt(&b, bytes.NewReader(data), cryptOpts); err != nil {
+		return err
+	}
+
+	createOpts := objects.CreateOpts{
+		Content:     &b,
+		ContentType: "application/octet-stream",
+	}
+
+	name := SwiftIndexPrefix + entry.Object
+	if _, err := objects.Create(client, storageContainer, name, createOpts).Extract(); err != nil {
+		return fmt.Errorf("Unable to upload index entry %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// SwiftReadIndexEntry downloads and decrypts the index entry of an opaque
+// object name.
+func SwiftReadIndexEntry(client *gophercloud.ServiceClient, storageContainer, objectName string, cryptOpts CryptOpts) (*IndexEntry, error) {
+	downloadOpts := SwiftDownloadOpts{
+		StorageContainer: storageContainer,
+		ObjectName:       SwiftIndexPrefix + objectName,
+	}
+
+	result, err := SwiftDownloadObject(client, downloadOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Content.Close()
+
+	var b bytes.Buffer
+	if err := Decrypt(&b, io.LimitReader(result.Content, 1024*1024), cryptOpts); err != nil {
+		return nil, fmt.Errorf("Unable to decrypt index entry %s: %s", objectName, err)
+	}
+
+	var entry IndexEntry
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		return nil, fmt.Errorf("Unable to parse index entry %s: %s", objectName, err)
+	}
+
+	return &entry, nil
+}
+
+// SwiftReadIndex returns all index entries of a storage container.
+func SwiftReadIndex(client *gophercloud.ServiceClient, storageContainer string, cryptOpts CryptOpts) ([]IndexEntry, error) {
+	names, err := SwiftListObjects(client, storageContainer, SwiftIndexPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []IndexEntry
+	for _, name := range names {
+		entry, err := SwiftReadIndexEntry(client, storageContainer, strings.TrimPrefix(name, SwiftIndexPrefix), cryptOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, *entry)
+	}
+
+	return entries, nil
+}
+
+// SwiftNameImages sets the logical names of the images which have an entry
+// in the index. Images without a readable manifest are dated by their index
+// entries.
+func SwiftNameImages(images []SwiftImageSummary, entries []IndexEntry) []SwiftImageSummary {
+	byObject := map[string]IndexEntry{}
+	for _, entry := range entries {
+		byObject[entry.Object] = entry
+	}
+
+	named := make([]SwiftImageSummary, len(images))
+	for i, image := range images {
+		if entry, ok := byObject[image.Name]; ok {
+			image.LogicalName = entry.Name
+			if image.Manifest == nil && !entry.Created.IsZero() {
+				image.Created = entry.Created
+			}
+		}
+
+		named[i] = image
+	}
+
+	return named
+}
+
+// SwiftResolveName returns the opaque name an object with the given logical
+// name is stored under. If the name key can be derived from cryptOpts, the
+// opaque name is calculated and only its index entry is checked. Otherwise
+// the whole index is searched.
+func SwiftResolveName(client *gophercloud.ServiceClient, storageContainer, name string, cryptOpts CryptOpts) (string, error) {
+	if cryptOpts.canDeriveNameKey() {
+		opaque, err := ObfuscateName(name, cryptOpts)
+		if err != nil {
+			return "", err
+		}
+
+		entry, err := SwiftReadIndexEntry(client, storageContainer, opaque, cryptOpts)
+		if err != nil {
+			return "", err
+		}
+
+		if entry.Name != name {
+			return "", fmt.Errorf("Index entry %s belongs to %s", opaque, entry.Name)
+		}
+
+		return opaque, nil
+	}
+
+	entries, err := SwiftReadIndex(client, storageContainer, cryptOpts)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry.Object, nil
+		}
+	}
+
+	return "", ErrObjectDoesNotExist{}
 }
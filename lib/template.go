@@ -0,0 +1,356 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// NameTemplateDateLayout is the layout of {date} placeholders without one.
+// It is the layout of --timestamp.
+const NameTemplateDateLayout = "20060102T150405Z"
+
+// NameTemplate builds object names from the details of an export, such as
+// {host}/{remote}/{name}/{date:2006-01-02T1504}. The placeholders are
+// {host}, {remote}, {name}, {type}, {fingerprint} and {date}, which takes
+// an optional Go time layout. Names built by a template can be matched
+// against it to recover the details.
+type NameTemplate struct {
+	raw   string
+	parts []templatePart
+	re    *regexp.Regexp
+}
+
+// templatePart is either literal text or a placeholder.
+type templatePart struct {
+	literal string
+	field   string
+	layout  string
+}
+
+// NameValues are the details an object name is built from.
+type NameValues struct {
+	Host        string
+	Remote      string
+	Name        string
+	Type        string
+	Fingerprint string
+	Date        time.Time
+}
+
+// ParseNameTemplate parses a template.
+func ParseNameTemplate(s string) (*NameTemplate, error) {
+	t := &NameTemplate{raw: s}
+	pattern := "^"
+	for rest := s; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			pattern += regexp.QuoteMeta(rest)
+			break
+		}
+
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+			pattern += regexp.QuoteMeta(rest[:start])
+		}
+
+		end := strings.IndexByte(rest, '}')
+		if end < start {
+			return nil, fmt.Errorf("Invalid name template %s: unterminated placeholder", s)
+		}
+
+		field := rest[start+1 : end]
+		part := templatePart{field: field}
+		if strings.HasPrefix(field, "date") {
+			part.field = "date"
+			part.layout = NameTemplateDateLayout
+			if layout := strings.TrimPrefix(field, "date"); layout != "" {
+				if !strings.HasPrefix(layout, ":") || len(layout) == 1 {
+					return nil, fmt.Errorf("Invalid name template %s: invalid placeholder {%s}", s, field)
+				}
+				part.layout = layout[1:]
+			}
+		}
+
+		switch part.field {
+		case "host", "remote", "name", "fingerprint", "type":
+			if t.has(part.field) {
+				return nil, fmt.Errorf("Invalid name template %s: {%s} is used more than once", s, part.field)
+			}
+			pattern += "(?P<" + part.field + ">" + fieldPattern(part.field) + ")"
+		case "date":
+			if t.has("date") {
+				return nil, fmt.Errorf("Invalid name template %s: {date} is used more than once", s)
+			}
+			datePart, _ := datePattern(part.layout)
+			pattern += "(?P<date>" + datePart + ")"
+		default:
+			return nil, fmt.Errorf("Invalid name template %s: unknown placeholder {%s}", s, field)
+		}
+
+		// The values of adjacent placeholders can only be told apart if
+		// all but one of them have a fixed width.
+		if n := len(t.parts); n > 0 && t.parts[n-1].field != "" {
+			variable := 0
+			for i := n - 1; i >= 0 && t.parts[i].field != ""; i-- {
+				if !t.parts[i].fixedWidth() {
+					variable++
+				}
+			}
+
+			if !part.fixedWidth() {
+				variable++
+			}
+
+			if variable > 1 {
+				return nil, fmt.Errorf("Invalid name template %s: {%s} and {%s} need text between them", s, t.parts[n-1].field, part.field)
+			}
+		}
+
+		t.parts = append(t.parts, part)
+		rest = rest[end+1:]
+	}
+
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("Name template is empty")
+	}
+
+	re, err := regexp.Compile(pattern + "$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid name template %s: %s", s, err)
+	}
+	t.re = re
+
+	return t, nil
+}
+
+func (t *NameTemplate) String() string {
+	return t.raw
+}
+
+// has determines if the template has a placeholder.
+func (t *NameTemplate) has(field string) bool {
+	for _, p := range t.parts {
+		if p.field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasDate determines if the template has a {date} placeholder, so each
+// export gets a name of its own.
+func (t *NameTemplate) HasDate() bool {
+	return t.has("date")
+}
+
+// HasName determines if the template has a {name} placeholder.
+func (t *NameTemplate) HasName() bool {
+	return t.has("name")
+}
+
+// Prefix returns the literal text before the first placeholder. All names
+// built by the template start with it.
+func (t *NameTemplate) Prefix() string {
+	if len(t.parts) > 0 && t.parts[0].field == "" {
+		return t.parts[0].literal
+	}
+
+	return ""
+}
+
+// Expand builds an object name. Every placeholder must have a value.
+func (t *NameTemplate) Expand(v NameValues) (string, error) {
+	return t.expand(v, len(t.parts))
+}
+
+// PointerName returns the name of the @latest pointer to the exports of a
+// template with a {date} placeholder. It is the name up to the date, so
+// exports of the same source share it.
+func (t *NameTemplate) PointerName(v NameValues) (string, error) {
+	n := len(t.parts)
+	for i, p := range t.parts {
+		if p.field == "date" {
+			n = i
+			break
+		}
+	}
+
+	base, err := t.expand(v, n)
+	if err != nil {
+		return "", err
+	}
+
+	base = strings.TrimRight(base, "/-_.@")
+	if base == "" {
+		return "", fmt.Errorf("Name template %s has nothing before {date} to name the @latest pointer", t.raw)
+	}
+
+	return base + SwiftLatestSuffix, nil
+}
+
+func (t *NameTemplate) expand(v NameValues, n int) (string, error) {
+	var name string
+	for _, p := range t.parts[:n] {
+		var value string
+		switch p.field {
+		case "":
+			value = p.literal
+		case "host":
+			value = v.Host
+		case "remote":
+			value = v.Remote
+		case "name":
+			value = v.Name
+		case "type":
+			value = v.Type
+		case "fingerprint":
+			value = v.Fingerprint
+		case "date":
+			if !v.Date.IsZero() {
+				value = v.Date.UTC().Format(p.layout)
+			}
+		}
+
+		if value == "" {
+			return "", fmt.Errorf("No value for {%s} in name template %s", p.field, t.raw)
+		}
+
+		name += value
+	}
+
+	return name, nil
+}
+
+// Match determines if an object name was built by the template and returns
+// the values it was built from. Placeholders which are not in the template
+// are left empty.
+func (t *NameTemplate) Match(objectName string) (*NameValues, bool) {
+	m := t.re.FindStringSubmatch(objectName)
+	if m == nil {
+		return nil, false
+	}
+
+	v := &NameValues{}
+	for i, field := range t.re.SubexpNames() {
+		switch field {
+		case "host":
+			v.Host = m[i]
+		case "remote":
+			v.Remote = m[i]
+		case "name":
+			v.Name = m[i]
+		case "type":
+			v.Type = m[i]
+		case "fingerprint":
+			v.Fingerprint = m[i]
+		case "date":
+			for _, p := range t.parts {
+				if p.field != "date" {
+					continue
+				}
+
+				date, err := time.Parse(p.layout, m[i])
+				if err != nil {
+					return nil, false
+				}
+				v.Date = date
+			}
+		}
+	}
+
+	return v, true
+}
+
+// Source returns the LXD container or image the values of a matched name
+// describe, or "" if the template has no {name} placeholder.
+func (v NameValues) Source() string {
+	if v.Name == "" {
+		return ""
+	}
+
+	source := v.Name
+	if v.Remote != "" {
+		source = v.Remote + ":" + source
+	}
+
+	if v.Host != "" {
+		source = v.Host + "/" + source
+	}
+
+	return source
+}
+
+// fixedWidth determines if the values of a placeholder have a fixed width.
+// Only dates with zero-padded numbers do.
+func (p templatePart) fixedWidth() bool {
+	if p.field != "date" {
+		return false
+	}
+
+	_, fixed := datePattern(p.layout)
+	return fixed
+}
+
+func fieldPattern(field string) string {
+	switch field {
+	case "type":
+		return "container|image"
+	case "fingerprint":
+		return "[0-9a-f]+"
+	}
+
+	return "[^/]+?"
+}
+
+// paddedDateElements are the elements of Go time layouts which are numbers
+// zero-padded to their width, longest first.
+var paddedDateElements = []string{"2006", "002", "01", "02", "03", "04", "05", "06", "15"}
+
+// datePattern returns a pattern which matches dates formatted with layout,
+// and whether these dates have a fixed width. Zero-padded numbers have the
+// width of their layout element, while other numbers may vary in width.
+// Dates are checked by parsing them when names are matched.
+func datePattern(layout string) (string, bool) {
+	if strings.IndexFunc(layout, func(r rune) bool {
+		return r >= 'A' && r <= 'Z' && r != 'T' && r != 'Z' || r >= 'a' && r <= 'z'
+	}) >= 0 {
+		return ".+?", false
+	}
+
+	var pattern string
+	fixed := true
+	for i := 0; i < len(layout); {
+		if layout[i] < '0' || layout[i] > '9' {
+			pattern += regexp.QuoteMeta(layout[i : i+1])
+			i++
+			continue
+		}
+
+		width := 0
+		for _, element := range paddedDateElements {
+			if strings.HasPrefix(layout[i:], element) {
+				width = len(element)
+				break
+			}
+		}
+
+		if width > 0 {
+			pattern += fmt.Sprintf(`\d{%d}`, width)
+			i += width
+			continue
+		}
+
+		pattern += `\d+`
+		fixed = false
+		i++
+		for i < len(layout) && layout[i] >= '0' && layout[i] <= '9' {
+			i++
+		}
+	}
+
+	return pattern, fixed
+}
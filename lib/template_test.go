@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNameTemplateRoundTrip(t *testing.T) {
+	date := time.Date(2026, 1, 5, 12, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		template string
+		values   NameValues
+		name     string
+	}{
+		{
+			template: "{host}/{remote}/{name}/{date:2006-01-02T1504}",
+			values:   NameValues{Host: "web1", Remote: "local", Name: "foo", Date: date},
+			name:     "web1/local/foo/2026-01-05T1230",
+		},
+		{
+			template: "{name}@{date}",
+			values:   NameValues{Name: "foo", Date: date},
+			name:     "foo@20260105T123045Z",
+		},
+		{
+			template: "{name}{date:20060102}",
+			values:   NameValues{Name: "web1", Date: date},
+			name:     "web120260105",
+		},
+		{
+			template: "{date:20060102}{name}",
+			values:   NameValues{Name: "2web", Date: date},
+			name:     "202601052web",
+		},
+		{
+			template: "{name}{date:150405}.{type}",
+			values:   NameValues{Name: "db1", Type: "container", Date: date},
+			name:     "db1123045.container",
+		},
+		{
+			template: "backup-{name}.{type}.tar",
+			values:   NameValues{Name: "db.prod", Type: "image"},
+			name:     "backup-db.prod.image.tar",
+		},
+		{
+			template: "{type}/{fingerprint}/{date:2006-01-02}",
+			values:   NameValues{Type: "image", Fingerprint: "abc123", Date: date},
+			name:     "image/abc123/2026-01-05",
+		},
+		{
+			template: "{name}-{date:2006-1-2}",
+			values:   NameValues{Name: "foo-1", Date: date},
+			name:     "foo-1-2026-1-5",
+		},
+		{
+			template: "{name}-{date:Jan 2 2006}",
+			values:   NameValues{Name: "foo", Date: date},
+			name:     "foo-Jan 5 2026",
+		},
+	}
+
+	for _, test := range tests {
+		tmpl, err := ParseNameTemplate(test.template)
+		if err != nil {
+			t.Fatalf("%s: ParseNameTemplate failed: %s", test.template, err)
+		}
+
+		name, err := tmpl.Expand(test.values)
+		if err != nil {
+			t.Errorf("%s: Expand failed: %s", test.template, err)
+			continue
+		}
+
+		if name != test.name {
+			t.Errorf("%s: expected %s, got %s", test.template, test.name, name)
+		}
+
+		v, ok := tmpl.Match(name)
+		if !ok {
+			t.Errorf("%s: %s does not match", test.template, name)
+			continue
+		}
+
+		if v.Host != test.values.Host || v.Remote != test.values.Remote || v.Name != test.values.Name ||
+			v.Type != test.values.Type || v.Fingerprint != test.values.Fingerprint {
+			t.Errorf("%s: expected %+v, got %+v", test.template, test.values, *v)
+		}
+
+		if tmpl.HasDate() && v.Date.IsZero() {
+			t.Errorf("%s: no date matched", test.template)
+		}
+
+		// The matched values build the same name again.
+		again, err := tmpl.Expand(*v)
+		if err != nil || again != name {
+			t.Errorf("%s: matched values build %s instead of %s: %v", test.template, again, name, err)
+		}
+	}
+}
+
+func TestNameTemplateNoMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		name     string
+	}{
+		{"{name}@{date}", "foo@latest"},
+		{"{name}@{date}", "foo@2026010512304Z"},
+		{"{name}@{date}", "foo@20261305T123045Z"},
+		{"{name}@{date}", "@20260105T123045Z"},
+		{"{name}/{date:2006-01-02}", "a/b/2026-01-05"},
+		{"{type}-{name}", "vm-foo"},
+		{"{fingerprint}-{name}", "XYZ-foo"},
+		{"backup-{name}", "backup-"},
+		{"backup-{name}", "xbackup-foo"},
+		{"{name}.tar", "foo.tar.gz"},
+		{"{name}{date:20060102}", "20260105"},
+		{"{name}{date:20060102}", "web1202601"},
+		{"{name}-{date:Jan 2 2006}", "foo-Foo 5 2026"},
+	}
+
+	for _, test := range tests {
+		tmpl, err := ParseNameTemplate(test.template)
+		if err != nil {
+			t.Fatalf("%s: ParseNameTemplate failed: %s", test.template, err)
+		}
+
+		if v, ok := tmpl.Match(test.name); ok {
+			t.Errorf("%s: %s matches with %+v", test.template, test.name, *v)
+		}
+	}
+}
+
+func TestParseNameTemplateInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"{name",
+		"foo/{nam}",
+		"{name}/{name}",
+		"{date}/{date:2006}",
+		"{date:}",
+		"{datex}",
+		"{name}{host}",
+		"{fingerprint}{name}",
+		"{name}{type}",
+		"{name}{date:2006-1-2}",
+		"{name}{date:Jan 2 2006}",
+		"{host}{date:20060102}{name}",
+	}
+
+	for _, test := range tests {
+		if _, err := ParseNameTemplate(test); err == nil {
+			t.Errorf("%s: ParseNameTemplate succeeded", test)
+		}
+	}
+}
+
+func TestNameTemplatePointerName(t *testing.T) {
+	tests := []struct {
+		template string
+		prefix   string
+		pointer  string
+	}{
+		{"{host}/{name}/{date}", "", "web1/foo@latest"},
+		{"backups/{name}-{date:2006-01-02}", "backups/", "backups/foo@latest"},
+		{"{name}{date:20060102}", "", "foo@latest"},
+	}
+
+	values := NameValues{Host: "web1", Name: "foo", Date: time.Now()}
+	for _, test := range tests {
+		tmpl, err := ParseNameTemplate(test.template)
+		if err != nil {
+			t.Fatalf("%s: ParseNameTemplate failed: %s", test.template, err)
+		}
+
+		if prefix := tmpl.Prefix(); prefix != test.prefix {
+			t.Errorf("%s: expected prefix %q, got %q", test.template, test.prefix, prefix)
+		}
+
+		pointer, err := tmpl.PointerName(values)
+		if err != nil {
+			t.Errorf("%s: PointerName failed: %s", test.template, err)
+			continue
+		}
+
+		if pointer != test.pointer {
+			t.Errorf("%s: expected %s, got %s", test.template, test.pointer, pointer)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VaultTransitOpts configures access to the transit secrets engine of
+// HashiCorp Vault.
+type VaultTransitOpts struct {
+	// Address is the URL of the Vault server.
+	Address string
+
+	// Token authenticates limbo to Vault.
+	Token string
+
+	// Namespace is the Vault Enterprise namespace of the mount, if any.
+	Namespace string
+
+	// Mount is the path the transit engine is mounted at.
+	Mount string
+
+	// Key is the name of the transit key data keys are wrapped with.
+	Key string
+
+	// CACert is a file with the CA certificate of the Vault server.
+	CACert string
+
+	// Retry configures how failed requests are retried.
+	Retry RetryOpts
+}
+
+// VaultTransit is a KeyManager which has Vault wrap data keys with a
+// transit key. The transit key never leaves Vault.
+type VaultTransit struct {
+	opts   VaultTransitOpts
+	client *http.Client
+}
+
+// vaultResponse is the part of a Vault response limbo uses.
+type vaultResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// NewVaultTransit returns a KeyManager using the Vault transit engine.
+func NewVaultTransit(opts VaultTransitOpts) (*VaultTransit, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("The address of the Vault server is required")
+	}
+
+	if opts.Token == "" {
+		return nil, fmt.Errorf("A Vault token is required")
+	}
+
+	if opts.Mount == "" {
+		opts.Mount = "transit"
+	}
+
+	config := &tls.Config{}
+	if v := opts.CACert; v != "" {
+		caCert, err := ioutil.ReadFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA file: %s", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		config.RootCAs = caCertPool
+	}
+
+	// Encrypting and decrypting has no side effects, so it is safe to
+	// retry.
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: config}
+	client := &http.Client{
+		Transport: newRetryTransport(transport, opts.Retry, "POST"),
+	}
+
+	return &VaultTransit{
+		opts:   opts,
+		client: client,
+	}, nil
+}
+
+func (v *VaultTransit) Name() string {
+	return KeyManagerVault
+}
+
+func (v *VaultTransit) KeyID() string {
+	return v.opts.Key
+}
+
+// WrapKey encrypts the data key with the transit key. The result is the
+// ciphertext returned by Vault, which records the version of the key.
+func (v *VaultTransit) WrapKey(key *[32]byte) ([]byte, error) {
+	req := map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(key[:]),
+	}
+
+	data, err := v.request("encrypt", v.opts.Key, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, ok := data["ciphertext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("Vault returned no ciphertext")
+	}
+
+	return []byte(ciphertext), nil
+}
+
+// UnwrapKey has Vault decrypt a data key wrapped with the transit key
+// keyID.
+func (v *VaultTransit) UnwrapKey(keyID string, wrapped []byte) (*[32]byte, error) {
+	req := map[string]string{
+		"ciphertext": string(wrapped),
+	}
+
+	data, err := v.request("decrypt", keyID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, ok := data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("Vault returned no plaintext")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("Vault returned an invalid data key")
+	}
+
+	var secretKey [32]byte
+	copy(secretKey[:], key)
+
+	return &secretKey, nil
+}
+
+// request calls an operation of the transit engine for a key and returns
+// the data of the response.
+func (v *VaultTransit) request(operation, key string, body interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	u := fmt.Sprintf("%s/v1/%s/%s/%s", strings.TrimRight(v.opts.Address, "/"),
+		strings.Trim(v.opts.Mount, "/"), operation, url.PathEscape(key))
+
+	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.opts.Token)
+	if v.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.opts.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Vault request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var r vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("Unable to parse Vault response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if len(r.Errors) > 0 {
+			return nil, fmt.Errorf("Vault returned %s: %s", resp.Status, strings.Join(r.Errors, ", "))
+		}
+		return nil, fmt.Errorf("Vault returned %s", resp.Status)
+	}
+
+	return r.Data, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+)
+
+var listFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-container",
+		Usage: "Swift Container to list the images of.",
+		Value: "limbo",
+	},
+	cli.StringFlag{
+		Name:  "prefix",
+		Usage: "Only list images whose object name starts with this prefix.",
+	},
+	cli.StringFlag{
+		Name:  "object-name-template",
+		Usage: "Template for object names, such as {host}/{remote}/{name}/{date:2006-01-02T1504}.",
+	},
+	cli.StringFlag{
+		Name:  "selector",
+		Usage: "Only list images whose labels match, such as release=2026.10,!pre-upgrade.",
+	},
+	cli.StringFlag{
+		Name:  "since",
+		Usage: "Only list images created since a date (2006-01-02 or RFC 3339) or a duration ago (12h, 7d).",
+	},
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format: table, json or csv.",
+		Value: "table",
+	},
+}
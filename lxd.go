@@ -41,14 +41,14 @@ var lxdFlags = []cli.Flag{
 	},
 	cli.StringFlag{
 		Name:  "tmpdir",
-		Usage: "Local temporary directory to store LXD resources.",
-		Value: "/tmp",
+		Usage: "Local directory to spool the segment being uploaded to. Defaults to $TMPDIR.",
 	},
 }
 
-func newLXDConfig(configDirectory string) (lib.LXDConfig, error) {
+func newLXDConfig(configDirectory string, retry lib.RetryOpts) (lib.LXDConfig, error) {
 	lxdConfig := lib.LXDConfig{
 		ConfigDirectory: configDirectory,
+		Retry:           retry,
 	}
 
 	return lib.NewLXDConfig(lxdConfig)
@@ -7,11 +7,15 @@ import (
 	"github.com/urfave/cli"
 )
 
+// version is the version of limbo. It is recorded in the manifest of every
+// export.
+var version = "0.0.1"
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "limbo"
 	app.Usage = "LXD Image Management"
-	app.Version = "0.0.1"
+	app.Version = version
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -36,6 +40,63 @@ func main() {
 				cmdImportSwift,
 			},
 		},
+		cli.Command{
+			Name:  "promote",
+			Usage: "promote an image to another storage container",
+			Subcommands: []cli.Command{
+				cmdPromoteSwift,
+			},
+		},
+		cli.Command{
+			Name:  "list",
+			Usage: "list the images stored in a backend",
+			Subcommands: []cli.Command{
+				cmdListSwift,
+			},
+		},
+		cli.Command{
+			Name:  "label",
+			Usage: "change the labels of a stored image",
+			Subcommands: []cli.Command{
+				cmdLabelSwift,
+			},
+		},
+		cli.Command{
+			Name:  "prune",
+			Usage: "remove old exports according to a retention policy",
+			Subcommands: []cli.Command{
+				cmdPruneSwift,
+			},
+		},
+		cli.Command{
+			Name:  "inspect",
+			Usage: "show the metadata of a stored image",
+			Subcommands: []cli.Command{
+				cmdInspectSwift,
+			},
+		},
+		cli.Command{
+			Name:  "verify",
+			Usage: "check the integrity of a stored image",
+			Subcommands: []cli.Command{
+				cmdVerifySwift,
+			},
+		},
+		cli.Command{
+			Name:  "restore-test",
+			Usage: "test that a stored image can be restored",
+			Subcommands: []cli.Command{
+				cmdRestoreTestSwift,
+			},
+		},
+		cli.Command{
+			Name:  "rekey",
+			Usage: "re-encrypt stored images with new secrets",
+			Subcommands: []cli.Command{
+				cmdRekeySwift,
+			},
+		},
+		cmdKeygen,
 	}
 
 	err := app.Run(os.Args)
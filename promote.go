@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+)
+
+var promoteFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "from",
+		Usage: "Swift Container to promote the image from.",
+	},
+	cli.StringFlag{
+		Name:  "to",
+		Usage: "Swift Container to promote the image to.",
+	},
+	cli.StringFlag{
+		Name:  "object-name",
+		Usage: "Object name of the image to promote.",
+	},
+	cli.BoolFlag{
+		Name:  "create-storage-container",
+		Usage: "Create the destination storage container if it does not exist.",
+	},
+	cli.BoolFlag{
+		Name:  "archive",
+		Usage: "Enable archiving on the destination storage container.",
+	},
+	cli.BoolFlag{
+		Name:  "require-verified",
+		Usage: "Refuse to promote images which have not been verified. Signed images also need --verify-key.",
+	},
+}
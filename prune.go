@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+)
+
+var pruneFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-container",
+		Usage: "Swift Container to prune.",
+		Value: "limbo",
+	},
+	cli.StringFlag{
+		Name:  "prefix",
+		Usage: "Only prune images whose object name starts with this prefix.",
+	},
+	cli.StringFlag{
+		Name:  "object-name-template",
+		Usage: "Template for object names, such as {host}/{remote}/{name}/{date:2006-01-02T1504}.",
+	},
+	cli.StringFlag{
+		Name:  "selector",
+		Usage: "Only prune images whose labels match, such as release=2026.10,!pre-upgrade.",
+	},
+	cli.IntFlag{
+		Name:  "keep-last",
+		Usage: "Keep the last n exports of each source.",
+	},
+	cli.IntFlag{
+		Name:  "keep-daily",
+		Usage: "Keep the newest export of each of the last n days with exports.",
+	},
+	cli.IntFlag{
+		Name:  "keep-weekly",
+		Usage: "Keep the newest export of each of the last n weeks with exports.",
+	},
+	cli.IntFlag{
+		Name:  "keep-monthly",
+		Usage: "Keep the newest export of each of the last n months with exports.",
+	},
+	cli.StringFlag{
+		Name:  "keep-within",
+		Usage: "Keep all exports made within a duration (12h, 30d) before the newest export of a source.",
+	},
+	cli.StringFlag{
+		Name:  "max-size",
+		Usage: "Remove the oldest exports until all kept exports fit into this size (500G, 2T).",
+	},
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Show what would be removed without deleting anything.",
+	},
+}
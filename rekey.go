@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+)
+
+var rekeyFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-container",
+		Usage: "Swift Container holding the images.",
+		Value: "limbo",
+	},
+	cli.StringSliceFlag{
+		Name:  "object-name",
+		Usage: "Object name of an image to rekey. Can be repeated.",
+	},
+	cli.StringFlag{
+		Name:  "prefix",
+		Usage: "Rekey all images whose object name starts with this prefix.",
+	},
+	cli.Int64Flag{
+		Name:  "segment-size",
+		Usage: "Size in MiB of the segments large images are uploaded in.",
+		Value: 1024,
+	},
+	cli.StringFlag{
+		Name:  "tmpdir",
+		Usage: "Local directory to spool the segment being uploaded to. Defaults to $TMPDIR.",
+	},
+	cli.BoolFlag{
+		Name:  "delete-old-segments",
+		Usage: "Delete the segments of the old objects which are no longer in use.",
+	},
+	cli.StringFlag{
+		Name:  "new-encrypt-mode",
+		Usage: "new encryption format: limbo or openpgp",
+		Value: "limbo",
+	},
+	cli.StringFlag{
+		Name:  "new-kdf-cost",
+		Usage: "cost of deriving a key from the new passphrase: interactive, moderate, sensitive or N,r,p",
+		Value: "interactive",
+	},
+	cli.StringFlag{
+		Name:   "new-pass",
+		Usage:  "new passphrase",
+		EnvVar: "LIMBO_NEW_PASS",
+	},
+	cli.StringFlag{
+		Name:  "new-pass-file",
+		Usage: "file containing the new passphrase",
+	},
+	cli.StringFlag{
+		Name:  "new-pass-command",
+		Usage: "command which prints the new passphrase",
+	},
+	cli.StringFlag{
+		Name:  "new-key-file",
+		Usage: "new secret key file, as created by keygen",
+	},
+	cli.StringSliceFlag{
+		Name:  "new-recipient",
+		Usage: "public key file to encrypt the images to. Can be repeated.",
+	},
+	cli.StringFlag{
+		Name:  "new-kms",
+		Usage: "key manager which wraps the new data keys: vault or barbican",
+	},
+	cli.StringFlag{
+		Name:  "new-kms-key",
+		Usage: "Vault transit key name or Barbican secret ID to wrap the new data keys with",
+	},
+}
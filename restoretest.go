@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+var restoreTestFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-container",
+		Usage: "Swift Container the image is stored in.",
+		Value: "limbo",
+	},
+	cli.StringFlag{
+		Name:  "object-name",
+		Usage: "Object name of the image to test.",
+	},
+	cli.StringFlag{
+		Name:  "lxd-config-directory",
+		Usage: "LXD Config Directory.",
+		Value: os.ExpandEnv("$HOME/.config/lxc"),
+	},
+	cli.StringFlag{
+		Name:  "remote",
+		Usage: "LXD remote to run the test on. Defaults to the default remote.",
+	},
+	cli.StringSliceFlag{
+		Name:  "profile",
+		Usage: "Profile of the test container. Can be repeated. Defaults to the default profile.",
+	},
+	cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Time to wait for the test container to be running.",
+		Value: 2 * time.Minute,
+	},
+	cli.StringFlag{
+		Name:  "exec",
+		Usage: "Command to run in the test container with sh -c.",
+	},
+	cli.IntFlag{
+		Name:  "exit-code",
+		Usage: "Expected exit code of --exec.",
+	},
+}
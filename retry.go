@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var retryFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "retries",
+		Usage: "Number of times to retry a failed Swift or LXD request.",
+		Value: 3,
+	},
+	cli.DurationFlag{
+		Name:  "retry-max-wait",
+		Usage: "Maximum time to wait between retries.",
+		Value: 30 * time.Second,
+	},
+}
+
+func newRetryOpts(ctx *cli.Context, log *logrus.Logger) lib.RetryOpts {
+	return lib.RetryOpts{
+		Retries: ctx.Int("retries"),
+		MaxWait: ctx.Duration("retry-max-wait"),
+		Logger:  log,
+	}
+}
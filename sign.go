@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jtopjian/limbo/lib"
+
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ed25519"
+)
+
+var signFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "sign-key",
+		Usage:  "signing key file to sign the image with",
+		EnvVar: "LIMBO_SIGN_KEY",
+	},
+	cli.StringFlag{
+		Name:   "verify-key",
+		Usage:  "file with trusted keys. Unsigned or tampered images are refused.",
+		EnvVar: "LIMBO_VERIFY_KEY",
+	},
+}
+
+// readSigningKey reads the key specified by --sign-key. nil is returned if
+// no key was given.
+func readSigningKey(ctx *cli.Context) (ed25519.PrivateKey, error) {
+	v := ctx.String("sign-key")
+	if v == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(v)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read signing key file: %s", err)
+	}
+
+	key, err := lib.ParseSigningKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", v, err)
+	}
+
+	return key, nil
+}
+
+// readVerifyKeys reads the keys specified by --verify-key. nil is returned
+// if no keys were given.
+func readVerifyKeys(ctx *cli.Context) ([]ed25519.PublicKey, error) {
+	v := ctx.String("verify-key")
+	if v == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(v)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read verification key file: %s", err)
+	}
+
+	keys, err := lib.ParseVerifyKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", v, err)
+	}
+
+	return keys, nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/gophercloud/gophercloud"
 	"github.com/jtopjian/limbo/lib"
 
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
@@ -25,9 +29,203 @@ var swiftFlags = []cli.Flag{
 		Name:  "object-name",
 		Usage: "Object name of the exported image.",
 	},
+	cli.StringFlag{
+		Name:  "object-name-template",
+		Usage: "Template for object names, such as {host}/{remote}/{name}/{date:2006-01-02T1504}.",
+	},
+}
+
+// exportFlags are the Swift flags which only apply to exports.
+var exportFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "timestamp",
+		Usage: "Append a timestamp to the object name and maintain a @latest pointer.",
+	},
+	cli.Int64Flag{
+		Name:  "segment-size",
+		Usage: "Size in MiB of the segments large images are uploaded in.",
+		Value: 1024,
+	},
+	cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "key=value or key label of the export. Can be repeated.",
+	},
+}
+
+// importFlags are the Swift flags which only apply to imports.
+var importFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "selector",
+		Usage: "Import the newest image whose labels match, such as release=2026.10,!pre-upgrade.",
+	},
+}
+
+// newNameTemplate parses --object-name-template. nil is returned if no
+// template was given.
+func newNameTemplate(ctx *cli.Context) (*lib.NameTemplate, error) {
+	v := ctx.String("object-name-template")
+	if v == "" {
+		return nil, nil
+	}
+
+	if ctx.String("object-name") != "" {
+		return nil, fmt.Errorf("--object-name cannot be used with --object-name-template")
+	}
+
+	return lib.ParseNameTemplate(v)
+}
+
+// templateImages returns the images whose names tmpl built. Images without
+// a manifest are dated by their names if the template has a {date}.
+func templateImages(images []lib.SwiftImageSummary, tmpl *lib.NameTemplate) []lib.SwiftImageSummary {
+	if tmpl == nil {
+		return images
+	}
+
+	var matched []lib.SwiftImageSummary
+	for _, image := range images {
+		v, ok := tmpl.Match(image.DisplayName())
+		if !ok {
+			continue
+		}
+
+		if image.Manifest == nil && !v.Date.IsZero() {
+			image.Created = v.Date
+		}
+
+		matched = append(matched, image)
+	}
+
+	return matched
+}
+
+// listImages returns the images in a storage container whose names start
+// with prefix, which tmpl built and whose labels match selector. With
+// --obfuscate-names, the logical names of obfuscated images are read from
+// the index, and prefix and tmpl are matched against them.
+func listImages(ctx *cli.Context, log *logrus.Logger, swiftClient *gophercloud.ServiceClient, storageContainer, prefix string, tmpl *lib.NameTemplate, selector lib.Selector) ([]lib.SwiftImageSummary, error) {
+	if !ctx.Bool("obfuscate-names") {
+		images, err := lib.SwiftListImages(swiftClient, storageContainer, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		return selectImages(templateImages(images, tmpl), selector), nil
+	}
+
+	// Opaque names do not start with the prefix of their logical names, so
+	// every image is listed.
+	images, err := lib.SwiftListImages(swiftClient, storageContainer, "")
+	if err != nil {
+		return nil, err
+	}
+
+	cryptOpts, err := newCryptOpts(ctx, log, "", false, swiftClient)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := lib.SwiftReadIndex(swiftClient, storageContainer, cryptOpts)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the index: %s", err)
+	}
+	log.Debugf("Read %d index entries", len(entries))
+
+	var matched []lib.SwiftImageSummary
+	for _, image := range lib.SwiftNameImages(images, entries) {
+		if strings.HasPrefix(image.DisplayName(), prefix) {
+			matched = append(matched, image)
+		}
+	}
+
+	return selectImages(templateImages(matched, tmpl), selector), nil
+}
+
+// findImage picks the newest image among those tmpl built and whose labels
+// match selector. Either may be nil. Placeholders match any value, so parts
+// of the template can be pinned by writing their values instead.
+func findImage(log *logrus.Logger, swiftClient *gophercloud.ServiceClient, storageContainer string, tmpl *lib.NameTemplate, selector lib.Selector) (string, error) {
+	var prefix string
+	if tmpl != nil {
+		prefix = tmpl.Prefix()
+	}
+
+	images, err := lib.SwiftListImages(swiftClient, storageContainer, prefix)
+	if err != nil {
+		return "", err
+	}
+	images = selectImages(templateImages(images, tmpl), selector)
+
+	if len(images) == 0 {
+		return "", fmt.Errorf("No image matches")
+	}
+
+	newest := images[0]
+	for _, image := range images[1:] {
+		if image.Created.After(newest.Created) || image.Created.Equal(newest.Created) && image.Name > newest.Name {
+			newest = image
+		}
+	}
+
+	log.Infof("%d images match. Picked the newest, %s", len(images), newest.Name)
+	return newest.Name, nil
+}
+
+// resolveImageName resolves the name of an image given on the command line
+// to the name of its meta object. With --obfuscate-names, the opaque name is
+// looked up in the index. @latest pointers are resolved to the export they
+// refer to.
+func resolveImageName(ctx *cli.Context, log *logrus.Logger, swiftClient *gophercloud.ServiceClient, storageContainer, objectName string, cryptOpts lib.CryptOpts) (string, error) {
+	isPointer := strings.HasSuffix(objectName, lib.SwiftLatestSuffix)
+	if ctx.Bool("obfuscate-names") {
+		opaque, err := lib.SwiftResolveName(swiftClient, storageContainer, objectName, cryptOpts)
+		if err != nil {
+			return "", fmt.Errorf("Unable to find %s in the index: %s", objectName, err)
+		}
+
+		log.Infof("Resolved %s to %s", objectName, opaque)
+		objectName = opaque
+	}
+
+	if isPointer {
+		target, err := lib.SwiftResolvePointer(swiftClient, storageContainer, objectName)
+		if err != nil {
+			return "", fmt.Errorf("Unable to resolve %s: %s", objectName, err)
+		}
+
+		log.Infof("Resolved %s to %s", objectName, target)
+		objectName = target
+	}
+
+	return objectName, nil
+}
+
+// readImageManifest reads the manifest of an image. nil is returned for
+// images exported by older versions of limbo, which have none. If the
+// image was verified, a manifest which is not covered by its signature is
+// not trusted and ignored.
+func readImageManifest(log *logrus.Logger, swiftClient *gophercloud.ServiceClient, storageContainer, objectName string, cryptOpts lib.CryptOpts, signature *lib.ImageSignature) (*lib.Manifest, error) {
+	manifestName := objectName + lib.SwiftManifestSuffix
+	if signature != nil && signature.Object(manifestName) == nil {
+		log.Debugf("%s is not signed and is ignored", manifestName)
+		return nil, nil
+	}
+
+	manifestSigned, err := signedObject(signature, manifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := lib.SwiftReadManifest(swiftClient, storageContainer, objectName, cryptOpts, manifestSigned)
+	if _, ok := err.(lib.ErrObjectDoesNotExist); ok && manifestSigned == nil {
+		log.Debugf("%s has no manifest", objectName)
+		return nil, nil
+	}
+
+	return manifest, err
 }
 
-func newSwiftClient(ctx *cli.Context) (*gophercloud.ServiceClient, error) {
+func newSwiftClient(ctx *cli.Context, retry lib.RetryOpts) (*gophercloud.ServiceClient, error) {
 	authOpts := lib.SwiftAuthOpts{
 		DomainID:         ctx.String("os-domain-id"),
 		DomainName:       ctx.String("os-domain-name"),
@@ -42,6 +240,7 @@ func newSwiftClient(ctx *cli.Context) (*gophercloud.ServiceClient, error) {
 		CACert:           ctx.String("os-cacert"),
 		Insecure:         ctx.Bool("os-insecure"),
 		Swauth:           ctx.Bool("os-swauth"),
+		Retry:            retry,
 	}
 
 	return lib.GetSwiftClient(authOpts)
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+)
+
+var verifyFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-container",
+		Usage: "Swift Container the image is stored in.",
+		Value: "limbo",
+	},
+	cli.StringFlag{
+		Name:  "object-name",
+		Usage: "Object name of the image to verify.",
+	},
+}